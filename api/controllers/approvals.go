@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	loggerCommon "github.com/junwei0117/logs-collector/pkg/common"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+	"github.com/junwei0117/logs-collector/pkg/tokens"
+)
+
+func GetApprovals(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, err := database.GetDB()
+	if err != nil {
+		logger.Logger.Errorf("Failed to connect to MongoDB: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	approvals := []*loggerCommon.ApprovalLog{}
+
+	addressStr := c.Param("address")
+	address := common.HexToAddress(addressStr)
+
+	filter, err := getEventFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queryFilter := storage.AndFilters(
+		bson.M{
+			"$or": []bson.M{
+				{"owner": address},
+				{"spender": address},
+			},
+		},
+		storage.MongoTransferFilter(filter),
+	)
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "100"))
+
+	offset := (page - 1) * pageSize
+	limit := pageSize
+
+	queryOptions := options.Find().SetSort(bson.M{"blocknumber": 1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := db.Collection(configs.MongoApprovalCollection).Find(ctx, queryFilter, queryOptions)
+	if err != nil {
+		logger.Logger.Errorf("Failed to execute MongoDB query: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &approvals); err != nil {
+		logger.Logger.Errorf("Failed to parse MongoDB result: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, approvals)
+}
+
+// allowance pairs the latest approval of a spender with the allowance the
+// contract reports now, which transfers made by the spender have reduced.
+type allowance struct {
+	*loggerCommon.ApprovalLog
+	Allowance *big.Int `json:"allowance"`
+}
+
+// GetAllowances returns the latest approval per chain, contract and spender granted by
+// the address, along with the outstanding allowance read from the contract. The
+// allowance is null when the contract cannot be called.
+func GetAllowances(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, err := database.GetDB()
+	if err != nil {
+		logger.Logger.Errorf("Failed to connect to MongoDB: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	approvals := []*loggerCommon.ApprovalLog{}
+
+	addressStr := c.Param("address")
+	address := common.HexToAddress(addressStr)
+
+	var spenderFilter bson.M
+	if spenderStr := c.Query("spender"); spenderStr != "" {
+		spenderFilter = bson.M{"spender": common.HexToAddress(spenderStr)}
+	}
+
+	filter, err := getEventFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queryFilter := storage.AndFilters(bson.M{"owner": address}, spenderFilter, storage.MongoTransferFilter(filter))
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: queryFilter}},
+		{{Key: "$sort", Value: bson.D{{Key: "blocknumber", Value: -1}, {Key: "index", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "chainid", Value: "$chainid"}, {Key: "contractaddress", Value: "$contractaddress"}, {Key: "spender", Value: "$spender"}}},
+			{Key: "approval", Value: bson.D{{Key: "$first", Value: "$$ROOT"}}},
+		}}},
+		{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$approval"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "blocknumber", Value: 1}}}},
+	}
+
+	cursor, err := db.Collection(configs.MongoApprovalCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		logger.Logger.Errorf("Failed to execute MongoDB query: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &approvals); err != nil {
+		logger.Logger.Errorf("Failed to parse MongoDB result: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	allowances := make([]*allowance, len(approvals))
+	for i, approval := range approvals {
+		allowances[i] = &allowance{ApprovalLog: approval}
+
+		chain, ok := configs.GetChain(strconv.FormatUint(approval.ChainID, 10))
+		if !ok {
+			continue
+		}
+
+		value, err := tokens.Allowance(chain, approval.ContractAddress, approval.Owner, approval.Spender)
+		if err != nil {
+			logger.Logger.Warnf("Failed to get allowance of %s on %s: %v", approval.Spender.Hex(), approval.ContractAddress.Hex(), err)
+			continue
+		}
+		allowances[i].Allowance = value
+	}
+
+	c.JSON(http.StatusOK, allowances)
+}
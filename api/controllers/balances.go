@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+
+	loggerCommon "github.com/junwei0117/logs-collector/pkg/common"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+func findBalances(c *gin.Context, filter storage.BalanceFilter) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store, err := storage.GetStore()
+	if err != nil {
+		logger.Logger.Errorf("Failed to open storage: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if chainStr := c.Query("chain"); chainStr != "" {
+		chain, ok := configs.GetChain(chainStr)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chain parameter"})
+			return
+		}
+		filter.ChainID = &chain.ChainID
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "100"))
+
+	offset := (page - 1) * pageSize
+	limit := pageSize
+
+	balances, err := store.FindBalances(ctx, filter, int64(offset), int64(limit))
+	if err != nil {
+		logger.Logger.Errorf("Failed to query balances: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, balances)
+}
+
+// GetBalances lists the tokens an address holds, largest balances first. With
+// at_block or at_time, the balances held after that block are listed instead.
+func GetBalances(c *gin.Context) {
+	address := common.HexToAddress(c.Param("address"))
+	filter := storage.BalanceFilter{Account: &address}
+
+	if contractStr := c.Query("contract"); contractStr != "" {
+		contract := common.HexToAddress(contractStr)
+		filter.Contract = &contract
+	}
+
+	if c.Query("at_block") == "" && c.Query("at_time") == "" {
+		findBalances(c, filter)
+		return
+	}
+
+	getHistoricalBalances(c, filter)
+}
+
+func getHistoricalBalances(c *gin.Context, filter storage.BalanceFilter) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	if c.Query("at_block") != "" && c.Query("at_time") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot use both block and time filters"})
+		return
+	}
+
+	chain, err := getQueryChain(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	atBlock, err := parseUintQuery(c, "at_block", "Invalid atBlock parameter")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	atTime, err := parseUintQuery(c, "at_time", "Invalid atTime parameter")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if atTime != nil {
+		blockNumber, err := loggerCommon.BlockAtTime(chain, *atTime)
+		if err != nil {
+			logger.Logger.Errorf("Failed to find block at time %v: %v", *atTime, err)
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		atBlock = &blockNumber
+	}
+
+	balances, err := loggerCommon.BalancesAt(ctx, chain, *filter.Account, *atBlock)
+	if err != nil {
+		logger.Logger.Errorf("Failed to compute balances: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	result := []*storage.Balance{}
+	for _, balance := range balances {
+		if filter.Contract == nil || balance.ContractAddress == *filter.Contract {
+			result = append(result, balance)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blockNumber": *atBlock, "balances": result})
+}
+
+func getHoldersFilter(c *gin.Context) (storage.BalanceFilter, error) {
+	contract := common.HexToAddress(c.Param("address"))
+	filter := storage.BalanceFilter{Contract: &contract, Positive: true}
+
+	if chainStr := c.Query("chain"); chainStr != "" {
+		chain, ok := configs.GetChain(chainStr)
+		if !ok {
+			return filter, errors.New("Invalid chain parameter")
+		}
+		filter.ChainID = &chain.ChainID
+	}
+
+	if tokenIDStr := c.Query("token_id"); tokenIDStr != "" {
+		tokenID, ok := new(big.Int).SetString(tokenIDStr, 10)
+		if !ok || tokenID.Sign() < 0 {
+			return filter, errors.New("Invalid tokenId parameter")
+		}
+		filter.TokenID = tokenID
+	}
+
+	return filter, nil
+}
+
+// GetHolders lists the accounts holding a token, largest balances first unless
+// order=asc, along with their count. Collections list a balance per token ID,
+// but accounts holding several are counted once.
+func GetHolders(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	filter, err := getHoldersFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order parameter"})
+		return
+	}
+
+	store, err := storage.GetStore()
+	if err != nil {
+		logger.Logger.Errorf("Failed to open storage: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "100"))
+
+	offset := (page - 1) * pageSize
+	limit := pageSize
+
+	holders, err := store.FindBalances(ctx, filter, int64(offset), int64(limit))
+	if err != nil {
+		logger.Logger.Errorf("Failed to query holders: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	count, err := store.CountHolders(ctx, filter)
+	if err != nil {
+		logger.Logger.Errorf("Failed to count holders: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count, "holders": holders})
+}
+
+// GetHolderDistribution reads every holder of the token, so it is slower than
+// the other holder queries on widely held tokens.
+func GetHolderDistribution(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	filter, err := getHoldersFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	distribution, err := loggerCommon.HolderDistribution(ctx, filter)
+	if err != nil {
+		logger.Logger.Errorf("Failed to compute holder distribution: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, distribution)
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
+)
+
+// GetChains leaves the endpoint URLs out, as provider URLs often embed API
+// keys. Only their hosts are listed along with their health.
+func GetChains(c *gin.Context) {
+	chains := []gin.H{}
+	for _, chain := range configs.Chains {
+		chains = append(chains, gin.H{
+			"name":      chain.Name,
+			"chainId":   chain.ChainID,
+			"fromBlock": chain.FromBlock,
+			"endpoints": gin.H{
+				"http":      rpcpool.HTTP(chain).Stats(),
+				"websocket": rpcpool.Websocket(chain).Stats(),
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, chains)
+}
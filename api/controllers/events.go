@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	loggerCommon "github.com/junwei0117/logs-collector/pkg/common"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/events"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+func GetEventDefinitions(c *gin.Context) {
+	c.JSON(http.StatusOK, events.Definitions())
+}
+
+func GetEventLogs(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	definition, ok := events.GetDefinition(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown event"})
+		return
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		logger.Logger.Errorf("Failed to connect to MongoDB: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	eventLogs := []*loggerCommon.EventLog{}
+
+	filter, err := getEventFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queryFilter := storage.MongoTransferFilter(filter)
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "100"))
+
+	offset := (page - 1) * pageSize
+	limit := pageSize
+
+	queryOptions := options.Find().SetSort(bson.M{"blocknumber": 1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := db.Collection(definition.Collection).Find(ctx, queryFilter, queryOptions)
+	if err != nil {
+		logger.Logger.Errorf("Failed to execute MongoDB query: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &eventLogs); err != nil {
+		logger.Logger.Errorf("Failed to parse MongoDB result: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, eventLogs)
+}
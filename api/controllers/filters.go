@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+func parseUintQuery(c *gin.Context, key string, message string) (*uint64, error) {
+	valueStr := c.Query(key)
+	if valueStr == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.ParseUint(valueStr, 10, 64)
+	if err != nil {
+		return nil, errors.New(message)
+	}
+	return &value, nil
+}
+
+func parseBigIntQuery(c *gin.Context, key string, message string) (*big.Int, error) {
+	valueStr := c.Query(key)
+	if valueStr == "" {
+		return nil, nil
+	}
+
+	value, ok := new(big.Int).SetString(valueStr, 10)
+	if !ok || value.Sign() < 0 || value.BitLen() > 256 {
+		return nil, errors.New(message)
+	}
+	return value, nil
+}
+
+// getTransferFilter reads the transfer query parameters shared by the
+// transfers and addresses endpoints.
+func getTransferFilter(c *gin.Context) (storage.TransferFilter, error) {
+	filter := storage.TransferFilter{}
+	var err error
+
+	if (c.Query("from_block") != "" || c.Query("to_block") != "") && (c.Query("from_time") != "" || c.Query("to_time") != "") {
+		return filter, errors.New("Cannot use both block and time filters")
+	}
+
+	if filter.FromBlock, err = parseUintQuery(c, "from_block", "Invalid fromBlock parameter"); err != nil {
+		return filter, err
+	}
+	if filter.ToBlock, err = parseUintQuery(c, "to_block", "Invalid toBlock parameter"); err != nil {
+		return filter, err
+	}
+	if filter.FromTime, err = parseUintQuery(c, "from_time", "Invalid fromTime parameter"); err != nil {
+		return filter, err
+	}
+	if filter.ToTime, err = parseUintQuery(c, "to_time", "Invalid toTime parameter"); err != nil {
+		return filter, err
+	}
+
+	if filter.MinValue, err = parseBigIntQuery(c, "min_value", "Invalid minValue parameter"); err != nil {
+		return filter, err
+	}
+	if filter.MaxValue, err = parseBigIntQuery(c, "max_value", "Invalid maxValue parameter"); err != nil {
+		return filter, err
+	}
+
+	if filter.ChainID, err = parseChainQuery(c); err != nil {
+		return filter, err
+	}
+	if filter.Status, err = parseStatusQuery(c); err != nil {
+		return filter, err
+	}
+
+	switch c.Query("standard") {
+	case "", storage.TokenStandardERC20, storage.TokenStandardERC721, storage.TokenStandardERC1155:
+		filter.Standard = c.Query("standard")
+	default:
+		return filter, errors.New("Invalid standard parameter")
+	}
+
+	switch c.Query("sort") {
+	case "", storage.TransferSortBlock, storage.TransferSortValue:
+		filter.Sort = c.Query("sort")
+	default:
+		return filter, errors.New("Invalid sort parameter")
+	}
+
+	return filter, nil
+}
+
+// getEventFilter reads the chain, contract, block range and status parameters
+// of the endpoints serving events stored in MongoDB.
+func getEventFilter(c *gin.Context) (storage.TransferFilter, error) {
+	filter := storage.TransferFilter{}
+	var err error
+
+	if filter.FromBlock, err = parseUintQuery(c, "from_block", "Invalid fromBlock parameter"); err != nil {
+		return filter, err
+	}
+	if filter.ToBlock, err = parseUintQuery(c, "to_block", "Invalid toBlock parameter"); err != nil {
+		return filter, err
+	}
+	if filter.ChainID, err = parseChainQuery(c); err != nil {
+		return filter, err
+	}
+	if filter.Status, err = parseStatusQuery(c); err != nil {
+		return filter, err
+	}
+
+	if contractStr := c.Query("contract"); contractStr != "" {
+		contract := common.HexToAddress(contractStr)
+		filter.Contract = &contract
+	}
+
+	return filter, nil
+}
+
+func parseChainQuery(c *gin.Context) (*uint64, error) {
+	chainStr := c.Query("chain")
+	if chainStr == "" {
+		return nil, nil
+	}
+
+	chain, ok := configs.GetChain(chainStr)
+	if !ok {
+		return nil, errors.New("Invalid chain parameter")
+	}
+	return &chain.ChainID, nil
+}
+
+func parseStatusQuery(c *gin.Context) (string, error) {
+	switch status := c.Query("status"); status {
+	case "", storage.TransferStatusPending, storage.TransferStatusConfirmed:
+		return status, nil
+	default:
+		return "", errors.New("Invalid status parameter")
+	}
+}
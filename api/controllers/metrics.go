@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	loggerCommon "github.com/junwei0117/logs-collector/pkg/common"
+)
+
+func GetMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"blockCache": loggerCommon.GetBlockCacheStats(),
+	})
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	loggerCommon "github.com/junwei0117/logs-collector/pkg/common"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+func GetOwnershipTransfers(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, err := database.GetDB()
+	if err != nil {
+		logger.Logger.Errorf("Failed to connect to MongoDB: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ownershipTransfers := []*loggerCommon.OwnershipTransferredLog{}
+
+	addressStr := c.Param("address")
+	address := common.HexToAddress(addressStr)
+
+	filter, err := getEventFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Contract = &address
+
+	queryFilter := storage.MongoTransferFilter(filter)
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "100"))
+
+	offset := (page - 1) * pageSize
+	limit := pageSize
+
+	queryOptions := options.Find().SetSort(bson.M{"blocknumber": 1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := db.Collection(configs.MongoOwnershipCollection).Find(ctx, queryFilter, queryOptions)
+	if err != nil {
+		logger.Logger.Errorf("Failed to execute MongoDB query: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &ownershipTransfers); err != nil {
+		logger.Logger.Errorf("Failed to parse MongoDB result: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ownershipTransfers)
+}
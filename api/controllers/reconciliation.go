@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/reconciliation"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+// GetReconciliation returns the last reconciliation of every chain and the
+// discrepancies found so far, latest first.
+func GetReconciliation(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store, err := storage.GetStore()
+	if err != nil {
+		logger.Logger.Errorf("Failed to open storage: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var chainID *uint64
+	if chainStr := c.Query("chain"); chainStr != "" {
+		chain, ok := configs.GetChain(chainStr)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chain parameter"})
+			return
+		}
+		chainID = &chain.ChainID
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "100"))
+
+	offset := (page - 1) * pageSize
+	limit := pageSize
+
+	discrepancies, err := store.FindDiscrepancies(ctx, chainID, int64(offset), int64(limit))
+	if err != nil {
+		logger.Logger.Errorf("Failed to query discrepancies: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reports":       reconciliation.Reports(),
+		"discrepancies": discrepancies,
+	})
+}
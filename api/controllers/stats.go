@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+var volumeIntervals = map[string]uint64{
+	"hour": storage.VolumeIntervalHour,
+	"day":  storage.VolumeIntervalDay,
+}
+
+// GetVolume returns the transfers of a token per hour or day. Values are summed
+// only within a token, so the token parameter is required.
+func GetVolume(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	store, err := storage.GetStore()
+	if err != nil {
+		logger.Logger.Errorf("Failed to open storage: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	filter, err := getTransferFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenStr := c.Query("token")
+	if !common.IsHexAddress(tokenStr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token parameter"})
+		return
+	}
+	token := common.HexToAddress(tokenStr)
+	filter.Contract = &token
+
+	interval, ok := volumeIntervals[c.DefaultQuery("interval", "day")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval parameter"})
+		return
+	}
+
+	buckets, err := store.TransferVolume(ctx, filter, interval)
+	if err != nil {
+		logger.Logger.Errorf("Failed to aggregate transfer volume: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, buckets)
+}
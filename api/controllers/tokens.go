@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+	"github.com/junwei0117/logs-collector/pkg/tokens"
+)
+
+// formattedTransfer adds the token symbol and the amount in whole tokens to a
+// transfer.
+type formattedTransfer struct {
+	*storage.TransferLog
+	Symbol         string `json:"symbol"`
+	FormattedValue string `json:"formattedValue,omitempty"`
+}
+
+// getQueryChain returns the chain named by the chain parameter, or the only
+// configured chain when it is omitted.
+func getQueryChain(c *gin.Context) (configs.Chain, error) {
+	chainStr := c.Query("chain")
+	if chainStr == "" {
+		if len(configs.Chains) == 1 {
+			return configs.Chains[0], nil
+		}
+		return configs.Chain{}, errors.New("Missing chain parameter")
+	}
+
+	chain, ok := configs.GetChain(chainStr)
+	if !ok {
+		return configs.Chain{}, errors.New("Invalid chain parameter")
+	}
+	return chain, nil
+}
+
+// formatTransfers looks up the token of every transfer. Tokens whose metadata
+// cannot be read are left unformatted rather than failing the response.
+func formatTransfers(ctx context.Context, transfers []*storage.TransferLog) []*formattedTransfer {
+	formatted := make([]*formattedTransfer, len(transfers))
+	for i, transfer := range transfers {
+		formatted[i] = &formattedTransfer{TransferLog: transfer}
+
+		chain, ok := configs.GetChain(strconv.FormatUint(transfer.ChainID, 10))
+		if !ok {
+			continue
+		}
+
+		tokenInfo, err := tokens.GetToken(ctx, chain, transfer.ContractAddress)
+		if err != nil {
+			logger.Logger.Warnf("Failed to get token %s: %v", transfer.ContractAddress.Hex(), err)
+			continue
+		}
+
+		formatted[i].Symbol = tokenInfo.Symbol
+		if tokenInfo.Decimals != nil && transfer.Standard != storage.TokenStandardERC721 && transfer.Standard != storage.TokenStandardERC1155 {
+			formatted[i].FormattedValue = tokens.FormatAmount(transfer.Value, *tokenInfo.Decimals)
+		}
+	}
+
+	return formatted
+}
+
+func GetTokens(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store, err := storage.GetStore()
+	if err != nil {
+		logger.Logger.Errorf("Failed to open storage: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var chainID *uint64
+	if chainStr := c.Query("chain"); chainStr != "" {
+		chain, ok := configs.GetChain(chainStr)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chain parameter"})
+			return
+		}
+		chainID = &chain.ChainID
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "100"))
+
+	offset := (page - 1) * pageSize
+	limit := pageSize
+
+	result, err := store.FindTokens(ctx, chainID, int64(offset), int64(limit))
+	if err != nil {
+		logger.Logger.Errorf("Failed to query tokens: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetToken reads the metadata from the chain if the token was not seen yet.
+// Addresses that are not token contracts are not found.
+func GetToken(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	chain, err := getQueryChain(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenInfo, err := tokens.GetToken(ctx, chain, common.HexToAddress(c.Param("address")))
+	if errors.Is(err, tokens.ErrNotToken) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Logger.Errorf("Failed to get token: %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokenInfo)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/junwei0117/logs-collector/api/controllers"
+	"github.com/junwei0117/logs-collector/pkg/database"
 )
 
 func readBody(reader io.Reader) string {
@@ -37,6 +38,9 @@ func SetUpRouters() *gin.Engine {
 
 	apiRouter := r.Group("/api")
 
+	apiRouter.GET("/chains", controllers.GetChains)
+	apiRouter.GET("/metrics", controllers.GetMetrics)
+
 	transfersRouter := apiRouter.Group("/transfers")
 	{
 		transfersRouter.GET("", controllers.GetTransfers)
@@ -47,6 +51,42 @@ func SetUpRouters() *gin.Engine {
 	{
 		addressesRouter.GET(":address", controllers.GetAddresses)
 		addressesRouter.GET(":address/counters", controllers.GetAddressesCount)
+		addressesRouter.GET(":address/balances", controllers.GetBalances)
+	}
+
+	tokensRouter := apiRouter.Group("/tokens")
+	{
+		tokensRouter.GET("", controllers.GetTokens)
+		tokensRouter.GET(":address", controllers.GetToken)
+		tokensRouter.GET(":address/holders", controllers.GetHolders)
+		tokensRouter.GET(":address/holders/distribution", controllers.GetHolderDistribution)
+	}
+
+	statsRouter := apiRouter.Group("/stats")
+	{
+		statsRouter.GET("/volume", controllers.GetVolume)
+	}
+
+	adminRouter := apiRouter.Group("/admin")
+	{
+		adminRouter.GET("/reconciliation", controllers.GetReconciliation)
+	}
+
+	// Events other than transfers are only indexed into MongoDB.
+	if database.Enabled() {
+		addressesRouter.GET(":address/approvals", controllers.GetApprovals)
+		addressesRouter.GET(":address/allowances", controllers.GetAllowances)
+
+		eventsRouter := apiRouter.Group("/events")
+		{
+			eventsRouter.GET("", controllers.GetEventDefinitions)
+			eventsRouter.GET(":name", controllers.GetEventLogs)
+		}
+
+		contractsRouter := apiRouter.Group("/contracts")
+		{
+			contractsRouter.GET(":address/ownership", controllers.GetOwnershipTransfers)
+		}
 	}
 
 	return r
@@ -1,83 +1,329 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	routes "github.com/junwei0117/logs-collector/api/routers"
+	"github.com/junwei0117/logs-collector/pkg/checkpoints"
 	"github.com/junwei0117/logs-collector/pkg/collectors"
 	loggerCommon "github.com/junwei0117/logs-collector/pkg/common"
 	"github.com/junwei0117/logs-collector/pkg/configs"
 	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/events"
 	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/reconciliation"
+	"github.com/junwei0117/logs-collector/pkg/reorgs"
+	"github.com/junwei0117/logs-collector/pkg/storage"
 	"github.com/junwei0117/logs-collector/pkg/subscriber"
 )
 
 func init() {
+	configs.Parse()
 	logger.Init()
-	err := database.ConnectToMongoDB()
+	if configs.EventsConfig != "" && !database.Enabled() {
+		logger.Logger.Fatalf("[Events] eventsConfig requires mongoEndpoint, registered events are stored in MongoDB")
+	}
+	err := events.LoadDefinitions(configs.EventsConfig)
 	if err != nil {
-		logger.Logger.Fatalf("[Database] Failed to connect to MongoDB: %v", err)
+		logger.Logger.Fatalf("[Events] Failed to load event definitions: %v", err)
+	}
+	if database.Enabled() {
+		err = database.ConnectToMongoDB()
+		if err != nil {
+			logger.Logger.Fatalf("[Database] Failed to connect to MongoDB: %v", err)
+		}
 	}
 }
 
 func main() {
-	logs, err := subscriber.SubscribeToTransferLogs()
+	for i := range configs.Chains {
+		chain := &configs.Chains[i]
+		if chain.ChainID != 0 {
+			continue
+		}
+
+		chainID, err := collectors.GetChainID(*chain)
+		if err != nil {
+			logger.Logger.Fatalf("[Collector] [%s] Failed to get chain ID: %v", chain.Name, err)
+		}
+		chain.ChainID = chainID
+	}
+
+	if err := configs.CheckChainIDs(); err != nil {
+		logger.Logger.Fatalf("[Collector] Invalid chains config: %v", err)
+	}
+
+	if len(configs.Chains) == 1 && database.Enabled() {
+		assigned, err := loggerCommon.AssignChainID(configs.Chains[0].ChainID)
+		if err != nil {
+			logger.Logger.Fatalf("[Database] Failed to assign chain ID to existing events: %v", err)
+		}
+		if assigned > 0 {
+			logger.Logger.Infof("[Database] Assigned chain ID %v to %v existing events", configs.Chains[0].ChainID, assigned)
+		}
+	}
+
+	// Events are assigned a chain ID before the unique indexes, which include
+	// it, are created.
+	err := storage.Open()
 	if err != nil {
-		logger.Logger.Errorf("[Subscriber] Failed to subscribe to transfer events: %v", err)
+		logger.Logger.Fatalf("[Storage] Failed to open %s storage: %v", configs.Storage, err)
+	}
+
+	err = loggerCommon.CreateIndexes()
+	if err != nil {
+		logger.Logger.Fatalf("[Database] Failed to create indexes: %v", err)
+	}
+
+	for _, chain := range configs.Chains {
+		runChain(chain)
+		reconciliation.Start(chain)
 	}
 
+	r := routes.SetUpRouters()
+	r.Run(fmt.Sprintf(":%s", "8080"))
+}
+
+func runChain(chain configs.Chain) {
+	// The live subscriber may only advance the checkpoint once the backfill has
+	// caught up, otherwise a restart would skip blocks that were never synced.
+	var backfillDone int32
+
+	// The backfill syncs up to this block and the subscriber takes over from
+	// it, even when it only subscribes later.
+	latestBlock, err := collectors.GetLatestBlockNumber(chain)
+	if err != nil {
+		logger.Logger.Fatalf("[Collector] [%s] Failed to get latest block number: %v", chain.Name, err)
+	}
+
+	logs := subscriber.SubscribeToTransferLogs(chain, uint64(latestBlock))
+
 	go func() {
+		// A block whose events failed to be stored is fetched again after a
+		// restart, so the checkpoint is held below the lowest one.
+		var failedBlock uint64
+		var failed bool
+		var checkpointBlock uint64
+
 		for vLog := range logs {
-			transferLog, err := loggerCommon.HandleTransferLogs(vLog)
+			removed, err := reorgs.HandleLog(chain, vLog)
+			if err != nil {
+				logger.Logger.Errorf("[Subscriber] [%s] Failed to handle chain reorganization: %v", chain.Name, err)
+			}
+			if removed {
+				continue
+			}
+
+			records, err := loggerCommon.HandleLogs(chain, vLog)
 			if err != nil {
-				logger.Logger.Errorf("[Subscriber] Failed to handle transfer event: %v", err)
+				logger.Logger.Errorf("[Subscriber] [%s] Failed to handle event of block %v: %v", chain.Name, vLog.BlockNumber, err)
+				if !failed || vLog.BlockNumber < failedBlock {
+					failedBlock = vLog.BlockNumber
+					failed = true
+				}
+				continue
 			}
-			if transferLog != nil {
-				logger.Logger.Infof("[Subscriber] Received transfer event: %v", transferLog)
+			for _, record := range records {
+				logger.Logger.Infof("[Subscriber] [%s] Received event: %v", chain.Name, record)
+			}
+
+			if atomic.LoadInt32(&backfillDone) == 0 || vLog.BlockNumber == 0 {
+				continue
 			}
+
+			// Logs of a block arrive together, so the previous block is complete
+			// once the first log of the next one is stored.
+			nextCheckpoint := vLog.BlockNumber - 1
+			if failed {
+				if failedBlock == 0 {
+					continue
+				}
+				if nextCheckpoint >= failedBlock {
+					nextCheckpoint = failedBlock - 1
+				}
+			}
+			if nextCheckpoint <= checkpointBlock {
+				continue
+			}
+
+			err = checkpoints.SaveCheckpoint(chain.ChainID, configs.MongoCollection, nextCheckpoint)
+			if err != nil {
+				logger.Logger.Errorf("[Subscriber] [%s] Failed to save checkpoint: %v", chain.Name, err)
+				continue
+			}
+			checkpointBlock = nextCheckpoint
 		}
 	}()
 
+	if configs.Confirmations > 0 {
+		go func() {
+			for {
+				time.Sleep(time.Second * 5)
+
+				latestBlock, err := collectors.GetLatestBlockNumber(chain)
+				if err != nil {
+					logger.Logger.Errorf("[Confirmations] [%s] Failed to get latest block number: %v", chain.Name, err)
+					continue
+				}
+
+				promoted, err := loggerCommon.PromoteConfirmedTransferLogs(chain.ChainID, uint64(latestBlock))
+				if err != nil {
+					logger.Logger.Errorf("[Confirmations] [%s] Failed to promote confirmed events: %v", chain.Name, err)
+					continue
+				}
+				if promoted > 0 {
+					logger.Logger.Infof("[Confirmations] [%s] Confirmed %v events", chain.Name, promoted)
+				}
+			}
+		}()
+	}
+
 	go func() {
-		pastLogs, err := collectors.GetTransferLogs(configs.FromBlock)
+		fromBlock, err := checkpoints.GetResumeBlock(chain.ChainID, configs.MongoCollection, chain.FromBlock)
 		if err != nil {
-			logger.Logger.Errorf("[Collector] Failed to get transfer events: %v", err)
-		}
-
-		logger.Logger.Infof("[Collector] Syncing past logs since block %v", configs.FromBlock)
-
-		logChan := make(chan types.Log)
-		var wg sync.WaitGroup
-		wg.Add(configs.CollectorsWorks)
-
-		for i := 0; i < configs.CollectorsWorks; i++ {
-			go func() {
-				defer wg.Done()
-				for vLog := range logChan {
-					transferLog, err := loggerCommon.HandleTransferLogs(vLog)
-					if err != nil {
-						logger.Logger.Errorf("[Collector] Failed to handle transfer event: %v", err)
-					}
-					if transferLog != nil {
-						logger.Logger.Infof("[Collector] Received transfer event: %v", transferLog)
-					}
-				}
-			}()
+			logger.Logger.Errorf("[Collector] [%s] Failed to get checkpoint: %v", chain.Name, err)
+			return
 		}
 
-		for _, vLog := range pastLogs {
-			logChan <- vLog
+		if configs.Confirmations > 0 {
+			_, err = loggerCommon.PromoteConfirmedTransferLogs(chain.ChainID, uint64(latestBlock))
+			if err != nil {
+				logger.Logger.Errorf("[Collector] [%s] Failed to promote confirmed events: %v", chain.Name, err)
+				return
+			}
 		}
 
-		close(logChan)
+		logger.Logger.Infof("[Collector] [%s] Syncing past logs since block %v", chain.Name, fromBlock)
 
-		wg.Wait()
+		err = syncPastLogs(chain, configs.MongoCollection, fromBlock, latestBlock, collectors.FilterAddresses())
+		if err != nil {
+			logger.Logger.Errorf("[Collector] [%s] Failed to sync past logs: %v", chain.Name, err)
+			return
+		}
+
+		atomic.StoreInt32(&backfillDone, 1)
 
-		logger.Logger.Infof("[Collector] Done syncing past logs")
+		logger.Logger.Infof("[Collector] [%s] Done syncing past logs", chain.Name)
 	}()
 
-	r := routes.SetUpRouters()
-	r.Run(fmt.Sprintf(":%s", "8080"))
+	// Contracts added to an existing deployment only need their own history
+	// backfilled. Each one keeps a separate checkpoint so the main one is untouched.
+	go func() {
+		for _, contract := range configs.BackfillContracts {
+			if !loggerCommon.IsContractIndexed(contract) {
+				logger.Logger.Warnf("[Collector] [%s] Skipping backfill of %v, contract is not indexed", chain.Name, contract.Hex())
+				continue
+			}
+
+			checkpointCollection := fmt.Sprintf("%s:%s", configs.MongoCollection, contract.Hex())
+
+			fromBlock, err := checkpoints.GetResumeBlock(chain.ChainID, checkpointCollection, chain.FromBlock)
+			if err != nil {
+				logger.Logger.Errorf("[Collector] [%s] Failed to get checkpoint of %v: %v", chain.Name, contract.Hex(), err)
+				continue
+			}
+
+			latestBlock, err := collectors.GetLatestBlockNumber(chain)
+			if err != nil {
+				logger.Logger.Errorf("[Collector] [%s] Failed to get latest block number for backfill of %v: %v", chain.Name, contract.Hex(), err)
+				continue
+			}
+
+			logger.Logger.Infof("[Collector] [%s] Backfilling past logs of %v since block %v", chain.Name, contract.Hex(), fromBlock)
+
+			err = syncPastLogs(chain, checkpointCollection, fromBlock, latestBlock, []common.Address{contract})
+			if err != nil {
+				logger.Logger.Errorf("[Collector] [%s] Failed to backfill past logs of %v: %v", chain.Name, contract.Hex(), err)
+				continue
+			}
+
+			logger.Logger.Infof("[Collector] [%s] Done backfilling past logs of %v", chain.Name, contract.Hex())
+		}
+	}()
+}
+
+func syncPastLogs(chain configs.Chain, checkpointCollection string, fromBlock int64, latestBlock int64, addresses []common.Address) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	windows, errs := collectors.StreamTransferLogs(ctx, chain, fromBlock, latestBlock, addresses)
+
+	// Every window is split into one batch per worker, each written with bulk
+	// inserts.
+	workers := configs.CollectorsWorks
+	if workers < 1 {
+		workers = 1
+	}
+
+	batchChan := make(chan []types.Log)
+	defer close(batchChan)
+
+	var windowWg sync.WaitGroup
+	var failures int32
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for batch := range batchChan {
+				records, err := loggerCommon.HandleLogBatch(chain, batch)
+				if err != nil {
+					atomic.AddInt32(&failures, 1)
+					logger.Logger.Errorf("[Collector] [%s] Failed to handle events: %v", chain.Name, err)
+				}
+				for _, record := range records {
+					logger.Logger.Infof("[Collector] [%s] Received event: %v", chain.Name, record)
+				}
+				windowWg.Done()
+			}
+		}()
+	}
+
+	checkpointBlock := fromBlock - 1
+	for window := range windows {
+		blockNumbers := make([]uint64, len(window.Logs))
+		for i, vLog := range window.Logs {
+			blockNumbers[i] = vLog.BlockNumber
+		}
+		if err := loggerCommon.PrefetchBlockTimeStamps(chain, blockNumbers); err != nil {
+			logger.Logger.Warnf("[Collector] [%s] Failed to prefetch block timestamps from block %v to %v: %v", chain.Name, window.FromBlock, window.ToBlock, err)
+		}
+
+		batchSize := (len(window.Logs) + workers - 1) / workers
+		for start := 0; start < len(window.Logs); start += batchSize {
+			end := start + batchSize
+			if end > len(window.Logs) {
+				end = len(window.Logs)
+			}
+			windowWg.Add(1)
+			batchChan <- window.Logs[start:end]
+		}
+		windowWg.Wait()
+
+		if failures > 0 {
+			return fmt.Errorf("stopped at block %v after %v failed batches", window.FromBlock, failures)
+		}
+
+		if window.ToBlock-checkpointBlock < configs.CheckpointInterval && window.ToBlock < latestBlock {
+			continue
+		}
+
+		err := checkpoints.SaveCheckpoint(chain.ChainID, checkpointCollection, uint64(window.ToBlock))
+		if err != nil {
+			return fmt.Errorf("failed to save checkpoint: %v", err)
+		}
+		checkpointBlock = window.ToBlock
+
+		logger.Logger.Infof("[Collector] [%s] Synced past logs up to block %v", chain.Name, window.ToBlock)
+	}
+
+	if err := <-errs; err != nil {
+		return fmt.Errorf("failed to get events: %v", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,61 @@
+package checkpoints
+
+import (
+	"context"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+type Checkpoint = storage.Checkpoint
+
+func GetCheckpoint(chainID uint64, collection string) (*Checkpoint, error) {
+	store, err := storage.GetStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetCheckpoint(context.Background(), chainID, collection)
+}
+
+// SaveCheckpoint only ever moves the checkpoint forward, so callers may report
+// progress concurrently without regressing it.
+func SaveCheckpoint(chainID uint64, collection string, blockNumber uint64) error {
+	store, err := storage.GetStore()
+	if err != nil {
+		return err
+	}
+
+	return store.SaveCheckpoint(context.Background(), chainID, collection, blockNumber)
+}
+
+func ResetCheckpoint(chainID uint64, collection string, blockNumber uint64) error {
+	store, err := storage.GetStore()
+	if err != nil {
+		return err
+	}
+
+	return store.ResetCheckpoint(context.Background(), chainID, collection, blockNumber)
+}
+
+// GetResumeBlock returns the first block the collector still has to process.
+func GetResumeBlock(chainID uint64, collection string, fromBlock int64) (int64, error) {
+	if configs.Rescan {
+		var resetBlock uint64
+		if fromBlock > 0 {
+			resetBlock = uint64(fromBlock - 1)
+		}
+		return fromBlock, ResetCheckpoint(chainID, collection, resetBlock)
+	}
+
+	checkpoint, err := GetCheckpoint(chainID, collection)
+	if err != nil {
+		return 0, err
+	}
+
+	if checkpoint != nil && int64(checkpoint.BlockNumber)+1 > fromBlock {
+		return int64(checkpoint.BlockNumber) + 1, nil
+	}
+
+	return fromBlock, nil
+}
@@ -3,66 +3,251 @@ package collectors
 import (
 	"context"
 	"math/big"
+	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 
 	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/events"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
 )
 
 var (
-	Erc20TransferSig = []byte("Transfer(address,address,uint256)")
+	// ERC-721 shares the Transfer signature with ERC-20 but indexes the tokenId.
+	Erc20TransferSig         = []byte("Transfer(address,address,uint256)")
+	Erc1155TransferSingleSig = []byte("TransferSingle(address,address,address,uint256,uint256)")
+	Erc1155TransferBatchSig  = []byte("TransferBatch(address,address,address,uint256[],uint256[])")
+	Erc20ApprovalSig         = []byte("Approval(address,address,uint256)")
+	OwnershipTransferredSig  = []byte("OwnershipTransferred(address,address)")
 )
 
-func GetTransferLogs(fromBlock int64, toBlock ...int64) ([]types.Log, error) {
-	var endBlock int64
-	if len(toBlock) > 0 {
-		endBlock = toBlock[0]
-	} else {
-		client, err := ethclient.DialContext(context.Background(), configs.RPCEndpoint)
-		if err != nil {
-			return nil, err
-		}
-		block, err := client.BlockByNumber(context.Background(), nil)
-		if err != nil {
-			return nil, err
-		}
-		endBlock = block.Number().Int64()
+func TransferTopics() []common.Hash {
+	return []common.Hash{
+		crypto.Keccak256Hash(Erc20TransferSig),
+		crypto.Keccak256Hash(Erc1155TransferSingleSig),
+		crypto.Keccak256Hash(Erc1155TransferBatchSig),
+	}
+}
+
+// EventTopics lists every topic the collector and subscriber ingest, including
+// the events registered through configs.EventsConfig. Only transfers are kept
+// without MongoDB, so the other events are not requested then.
+func EventTopics() []common.Hash {
+	if !database.Enabled() {
+		return TransferTopics()
 	}
+	topics := append(TransferTopics(),
+		crypto.Keccak256Hash(Erc20ApprovalSig),
+		crypto.Keccak256Hash(OwnershipTransferredSig),
+	)
+	return append(topics, events.Topics()...)
+}
 
-	client, err := ethclient.DialContext(context.Background(), configs.WebsocketRPCEndpoint)
+// FilterAddresses extends the configs.Contracts allowlist with the contracts of
+// registered events. A nil result matches every contract.
+func FilterAddresses() []common.Address {
+	if len(configs.Contracts) == 0 || len(events.Definitions()) > 0 && events.Addresses() == nil {
+		return nil
+	}
+	return append(append([]common.Address{}, configs.Contracts...), events.Addresses()...)
+}
+
+// Providers reject eth_getLogs by result count or response size as often as by
+// block span, each with their own wording. Rate limits are worded alike ("rate
+// limit exceeded") but must go through the retries instead, so patterns stay
+// specific to results.
+var rangeErrorPatterns = []string{
+	"query returned more than",
+	"more than 10000 results",
+	"query exceeds max results",
+	"response size exceeded",
+	"response size should not greater than",
+	"block range",
+	"range is too large",
+	"too many blocks",
+	"log response size exceeded",
+}
+
+// Windows returning fewer logs than this are considered sparse, so the next
+// window is doubled.
+const sparseWindowLogs = 1000
+
+func GetChainID(chain configs.Chain) (uint64, error) {
+	var chainID *big.Int
+	err := rpcpool.HTTP(chain).Do(context.Background(), func(client rpcpool.Client) error {
+		var err error
+		chainID, err = client.ChainID(context.Background())
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	topic := crypto.Keccak256Hash(Erc20TransferSig)
+	return chainID.Uint64(), nil
+}
 
-	var logs []types.Log
+func GetLatestBlockNumber(chain configs.Chain) (int64, error) {
+	var header *types.Header
+	err := rpcpool.HTTP(chain).Do(context.Background(), func(client rpcpool.Client) error {
+		var err error
+		header, err = client.HeaderByNumber(context.Background(), nil)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return header.Number.Int64(), nil
+}
+
+func GetBlockHash(chain configs.Chain, blockNumber uint64) (common.Hash, error) {
+	var header *Header
+	err := rpcpool.HTTP(chain).Do(context.Background(), func(client rpcpool.Client) error {
+		var err error
+		header, err = HeaderByNumber(context.Background(), client, blockNumber)
+		return err
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	return header.Hash, nil
+}
+
+type LogWindow struct {
+	FromBlock int64
+	ToBlock   int64
+	Logs      []types.Log
+}
+
+// StreamTransferLogs emits the transfer logs of each block window as soon as it
+// is fetched. The windows channel is unbuffered, so a slow consumer holds the
+// producer back and at most one window is buffered ahead of it. Cancel ctx to
+// stop the producer early. An empty addresses list matches every contract.
+func StreamTransferLogs(ctx context.Context, chain configs.Chain, fromBlock int64, toBlock int64, addresses []common.Address) (<-chan LogWindow, <-chan error) {
+	windows := make(chan LogWindow)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(windows)
+		defer close(errs)
+
+		pool := rpcpool.HTTP(chain)
+		topics := EventTopics()
+		blockRange := configs.CollectorsBlockRange
+		// Windows do not grow back to a size the provider rejected.
+		maxBlockRange := configs.CollectorsMaxBlockRange
+
+		for blockStart := fromBlock; blockStart <= toBlock; {
+			blockEnd := blockStart + blockRange - 1
+			if blockEnd > toBlock {
+				blockEnd = toBlock
+			}
+
+			filter := ethereum.FilterQuery{
+				FromBlock: big.NewInt(blockStart),
+				ToBlock:   big.NewInt(blockEnd),
+				Addresses: addresses,
+				Topics:    [][]common.Hash{topics},
+			}
 
-	for blockStart := fromBlock; blockStart <= endBlock; blockStart += 2000 {
-		blockEnd := blockStart + 2000 - 1
-		if blockEnd > endBlock {
-			blockEnd = endBlock
+			blockLogs, err := filterLogs(ctx, pool, filter)
+			if err != nil {
+				if isRangeError(err) && blockRange > 1 {
+					blockRange /= 2
+					maxBlockRange = blockRange
+					logger.Logger.Debugf("[Collector] Shrinking block range to %v: %v", blockRange, err)
+					continue
+				}
+				errs <- err
+				return
+			}
+
+			select {
+			case windows <- LogWindow{FromBlock: blockStart, ToBlock: blockEnd, Logs: blockLogs}:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+
+			if len(blockLogs) < sparseWindowLogs && blockRange < maxBlockRange {
+				blockRange *= 2
+				if blockRange > maxBlockRange {
+					blockRange = maxBlockRange
+				}
+				logger.Logger.Debugf("[Collector] Growing block range to %v", blockRange)
+			}
+
+			blockStart = blockEnd + 1
 		}
+	}()
+
+	return windows, errs
+}
+
+// filterLogs retries transient errors only, each attempt on the healthiest
+// endpoint of the pool. Errors caused by the size of the range are returned
+// immediately so the caller can shrink the window instead.
+func filterLogs(ctx context.Context, pool *rpcpool.Pool, filter ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	var err error
+	var retries = configs.CollectorsRetries
+	var delay = time.Second * 1
 
-		filter := ethereum.FilterQuery{
-			FromBlock: big.NewInt(blockStart),
-			ToBlock:   big.NewInt(blockEnd),
-			Topics:    [][]common.Hash{{topic}},
+	for {
+		err = pool.Do(ctx, func(client rpcpool.Client) error {
+			var err error
+			logs, err = client.FilterLogs(ctx, filter)
+			return err
+		})
+		if err == nil || isRangeError(err) || retries <= 0 || ctx.Err() != nil {
+			return logs, err
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+		logger.Logger.Warnf("failed to filter logs from block %v to %v: %v. retrying in %v...", filter.FromBlock, filter.ToBlock, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		retries--
+	}
+}
+
+func isRangeError(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, pattern := range rangeErrorPatterns {
+		if strings.Contains(message, pattern) {
+			return true
+		}
+	}
+	return false
+}
 
-		blockLogs, err := client.FilterLogs(ctx, filter)
+func GetTransferLogs(chain configs.Chain, fromBlock int64, toBlock ...int64) ([]types.Log, error) {
+	var endBlock int64
+	if len(toBlock) > 0 {
+		endBlock = toBlock[0]
+	} else {
+		latestBlock, err := GetLatestBlockNumber(chain)
 		if err != nil {
 			return nil, err
 		}
+		endBlock = latestBlock
+	}
+
+	var logs []types.Log
 
-		logs = append(logs, blockLogs...)
+	windows, errs := StreamTransferLogs(context.Background(), chain, fromBlock, endBlock, FilterAddresses())
+	for window := range windows {
+		logs = append(logs, window.Logs...)
+	}
+
+	if err := <-errs; err != nil {
+		return nil, err
 	}
 
 	return logs, nil
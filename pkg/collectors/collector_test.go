@@ -0,0 +1,156 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool/rpcpooltest"
+)
+
+func TestStreamTransferLogs(t *testing.T) {
+	var logs []types.Log
+	for block := uint64(0); block <= 100; block += 7 {
+		logs = append(logs, types.Log{BlockNumber: block})
+	}
+
+	tests := []struct {
+		name          string
+		blockRange    int64
+		maxBlockRange int64
+		providerLimit uint64
+		fromBlock     int64
+		toBlock       int64
+		err           error
+		wantLogs      int
+		wantWindows   []int64
+		wantRequests  int
+		wantErr       bool
+	}{
+		{
+			name:          "single window",
+			blockRange:    200,
+			maxBlockRange: 200,
+			fromBlock:     0,
+			toBlock:       100,
+			wantLogs:      15,
+			wantWindows:   []int64{101},
+			wantRequests:  1,
+		},
+		{
+			name:          "sparse windows grow",
+			blockRange:    10,
+			maxBlockRange: 40,
+			fromBlock:     0,
+			toBlock:       100,
+			wantLogs:      15,
+			wantWindows:   []int64{10, 20, 40, 31},
+			wantRequests:  4,
+		},
+		{
+			name:          "range errors shrink the window",
+			blockRange:    40,
+			maxBlockRange: 40,
+			providerLimit: 15,
+			fromBlock:     0,
+			toBlock:       100,
+			wantLogs:      15,
+			// 40 and 20 are rejected once, then windows stay at 10.
+			wantWindows:  []int64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 1},
+			wantRequests: 13,
+		},
+		{
+			name:          "starts past the first block",
+			blockRange:    25,
+			maxBlockRange: 25,
+			fromBlock:     50,
+			toBlock:       100,
+			wantLogs:      7,
+			wantWindows:   []int64{25, 25, 1},
+			wantRequests:  3,
+		},
+		{
+			name:          "other errors are returned",
+			blockRange:    10,
+			maxBlockRange: 10,
+			fromBlock:     0,
+			toBlock:       100,
+			err:           errors.New("internal error"),
+			wantRequests:  1,
+			wantErr:       true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configs.CollectorsBlockRange = test.blockRange
+			configs.CollectorsMaxBlockRange = test.maxBlockRange
+			configs.CollectorsRetries = 0
+
+			client := &rpcpooltest.Client{Logs: logs, MaxBlockRange: test.providerLimit, Err: test.err}
+			rpcpool.SetDialer(client.Dialer())
+
+			chain := configs.Chain{Name: fmt.Sprintf("stream-%d", i), RPCEndpoints: []string{"http://node"}}
+			windows, errs := StreamTransferLogs(context.Background(), chain, test.fromBlock, test.toBlock, nil)
+
+			var gotLogs int
+			var gotWindows []int64
+			nextBlock := test.fromBlock
+			for window := range windows {
+				if window.FromBlock != nextBlock {
+					t.Fatalf("window starts at block %v, want %v", window.FromBlock, nextBlock)
+				}
+				nextBlock = window.ToBlock + 1
+				gotLogs += len(window.Logs)
+				gotWindows = append(gotWindows, window.ToBlock-window.FromBlock+1)
+			}
+			err := <-errs
+
+			if len(client.Queries) != test.wantRequests {
+				t.Errorf("sent %v requests, want %v", len(client.Queries), test.wantRequests)
+			}
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if nextBlock != test.toBlock+1 {
+				t.Fatalf("windows end at block %v, want %v", nextBlock-1, test.toBlock)
+			}
+			if gotLogs != test.wantLogs {
+				t.Errorf("got %v logs, want %v", gotLogs, test.wantLogs)
+			}
+			if fmt.Sprint(gotWindows) != fmt.Sprint(test.wantWindows) {
+				t.Errorf("got window sizes %v, want %v", gotWindows, test.wantWindows)
+			}
+		})
+	}
+}
+
+func TestIsRangeError(t *testing.T) {
+	tests := []struct {
+		err  string
+		want bool
+	}{
+		{"query returned more than 10000 results", true},
+		{"Log response size exceeded.", true},
+		{"block range is too large", true},
+		{"rate limit exceeded", false},
+		{"connection refused", false},
+	}
+
+	for _, test := range tests {
+		if got := isRangeError(errors.New(test.err)); got != test.want {
+			t.Errorf("isRangeError(%q) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
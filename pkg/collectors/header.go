@@ -0,0 +1,34 @@
+package collectors
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
+)
+
+// Header is the part of a block header the indexer reads, decoded from
+// eth_getBlockByNumber. Hash is the hash reported by the node: types.Header
+// recomputes it from the fields it knows, which differs on chains whose
+// headers carry other fields.
+type Header struct {
+	Number    hexutil.Uint64 `json:"number"`
+	Hash      common.Hash    `json:"hash"`
+	Timestamp hexutil.Uint64 `json:"timestamp"`
+}
+
+// HeaderByNumber reads the header of blockNumber from client.
+func HeaderByNumber(ctx context.Context, client rpcpool.Client, blockNumber uint64) (*Header, error) {
+	var header *Header
+	err := client.CallContext(ctx, &header, "eth_getBlockByNumber", hexutil.EncodeUint64(blockNumber), false)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, ethereum.NotFound
+	}
+	return header, nil
+}
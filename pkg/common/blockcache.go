@@ -0,0 +1,107 @@
+package common
+
+import (
+	"container/list"
+	"sync"
+)
+
+type BlockCacheStats struct {
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	StoreHits uint64 `json:"storeHits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// blockCache keeps the most recently used blocks in memory. Misses fall back
+// to the blocks collection and then to the chain.
+type blockCache struct {
+	sync.Mutex
+
+	// Read on use, as flags are only parsed after package initialization.
+	capacity func() int
+	entries  map[blockKey]*list.Element
+	order    *list.List
+
+	hits      uint64
+	storeHits uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newBlockCache(capacity func() int) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		entries:  make(map[blockKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (cache *blockCache) get(key blockKey) (*Block, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+
+	return element.Value.(*Block), true
+}
+
+func (cache *blockCache) add(block *Block) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	key := blockKey{chainID: block.ChainID, blockNumber: block.Number}
+	if element, ok := cache.entries[key]; ok {
+		element.Value = block
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(block)
+
+	for capacity := cache.capacity(); capacity > 0 && cache.order.Len() > capacity; {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		oldestBlock := oldest.Value.(*Block)
+		delete(cache.entries, blockKey{chainID: oldestBlock.ChainID, blockNumber: oldestBlock.Number})
+		cache.evictions++
+	}
+}
+
+func (cache *blockCache) remove(key blockKey) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+}
+
+func (cache *blockCache) record(hits uint64, storeHits uint64, misses uint64) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.hits += hits
+	cache.storeHits += storeHits
+	cache.misses += misses
+}
+
+func (cache *blockCache) stats() BlockCacheStats {
+	cache.Lock()
+	defer cache.Unlock()
+
+	return BlockCacheStats{
+		Size:      cache.order.Len(),
+		Capacity:  cache.capacity(),
+		Hits:      cache.hits,
+		StoreHits: cache.storeHits,
+		Misses:    cache.misses,
+		Evictions: cache.evictions,
+	}
+}
@@ -0,0 +1,307 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/junwei0117/logs-collector/pkg/collectors"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
+)
+
+// Number of headers requested per JSON-RPC batch.
+const blockHeaderBatchSize = 100
+
+// Block is the part of a block header the indexer needs. Blocks are stored in
+// configs.MongoBlockCollection, so restarts and other replicas reuse them.
+type Block struct {
+	ChainID   uint64      `json:"chainId"`
+	Number    uint64      `json:"number"`
+	Hash      common.Hash `json:"hash"`
+	Timestamp uint64      `json:"timestamp"`
+}
+
+type blockKey struct {
+	chainID     uint64
+	blockNumber uint64
+}
+
+var blocks = newBlockCache(func() int { return configs.BlockCacheSize })
+
+// Concurrent misses of the same block share a single lookup.
+var blockRequests singleflight.Group
+
+func GetBlockCacheStats() BlockCacheStats {
+	return blocks.stats()
+}
+
+func GetBlockTimeStamp(chain configs.Chain, blockNumber uint64) (uint64, error) {
+	block, err := GetBlock(chain, blockNumber)
+	if err != nil {
+		return 0, err
+	}
+	return block.Timestamp, nil
+}
+
+// GetBlock returns a cached block, or reads it from the chain and caches it.
+func GetBlock(chain configs.Chain, blockNumber uint64) (*Block, error) {
+	key := blockKey{chainID: chain.ChainID, blockNumber: blockNumber}
+	if block, ok := blocks.get(key); ok {
+		blocks.record(1, 0, 0)
+		return block, nil
+	}
+
+	block, err, _ := blockRequests.Do(fmt.Sprintf("%v:%v", key.chainID, key.blockNumber), func() (interface{}, error) {
+		block, ok, err := LookupBlock(chain.ChainID, blockNumber)
+		if err != nil || ok {
+			return block, err
+		}
+
+		header, err := getBlockHeader(chain, blockNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks.record(0, 0, 1)
+		return saveBlocks(chain.ChainID, []*collectors.Header{header})[0], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block.(*Block), nil
+}
+
+// LookupBlock only consults the cache and the blocks collection, without
+// reading from the chain. Without MongoDB only the cache is consulted.
+func LookupBlock(chainID uint64, blockNumber uint64) (*Block, bool, error) {
+	key := blockKey{chainID: chainID, blockNumber: blockNumber}
+	if block, ok := blocks.get(key); ok {
+		blocks.record(1, 0, 0)
+		return block, true, nil
+	}
+	if !database.Enabled() {
+		return nil, false, nil
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, false, err
+	}
+
+	block := &Block{}
+	err = db.Collection(configs.MongoBlockCollection).FindOne(context.Background(), bson.M{"chainid": chainID, "number": blockNumber}).Decode(block)
+	if err == mongo.ErrNoDocuments {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	blocks.record(0, 1, 0)
+	blocks.add(block)
+
+	return block, true, nil
+}
+
+func getBlockHeader(chain configs.Chain, blockNumber uint64) (*collectors.Header, error) {
+	var header *collectors.Header
+	var err error
+	var retries = 3
+	var delay = time.Second * 1
+
+	for retries > 0 {
+		err = rpcpool.HTTP(chain).Do(context.Background(), func(client rpcpool.Client) error {
+			var err error
+			header, err = collectors.HeaderByNumber(context.Background(), client, blockNumber)
+			return err
+		})
+		if err == nil {
+			return header, nil
+		}
+
+		logger.Logger.Warnf("failed to get block header by number: %v. retrying in %v...", err, delay)
+		time.Sleep(delay)
+		retries--
+	}
+
+	return nil, errors.New("failed to get block header by number: " + err.Error())
+}
+
+// saveBlocks caches the headers and stores them for other processes. Failing
+// to store them only costs another lookup later, so errors are logged.
+func saveBlocks(chainID uint64, headers []*collectors.Header) []*Block {
+	var saved []*Block
+	var models []mongo.WriteModel
+
+	for _, header := range headers {
+		block := &Block{
+			ChainID:   chainID,
+			Number:    uint64(header.Number),
+			Hash:      header.Hash,
+			Timestamp: uint64(header.Timestamp),
+		}
+		blocks.add(block)
+		saved = append(saved, block)
+
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"chainid": block.ChainID, "number": block.Number}).
+			SetReplacement(block).
+			SetUpsert(true))
+	}
+	if !database.Enabled() {
+		return saved
+	}
+
+	db, err := database.GetDB()
+	if err == nil {
+		_, err = db.Collection(configs.MongoBlockCollection).BulkWrite(context.Background(), models, options.BulkWrite().SetOrdered(false))
+	}
+	if err != nil {
+		logger.Logger.Warnf("failed to store blocks: %v", err)
+	}
+
+	return saved
+}
+
+// PrefetchBlockTimeStamps caches the given blocks, reading the ones not stored
+// yet with batched header requests, so the logs of a backfill window do not
+// each wait on their own round trip.
+func PrefetchBlockTimeStamps(chain configs.Chain, blockNumbers []uint64) error {
+	var uncached []uint64
+	seen := make(map[uint64]bool)
+	for _, blockNumber := range blockNumbers {
+		if seen[blockNumber] {
+			continue
+		}
+		seen[blockNumber] = true
+
+		if _, ok := blocks.get(blockKey{chainID: chain.ChainID, blockNumber: blockNumber}); !ok {
+			uncached = append(uncached, blockNumber)
+		}
+	}
+	if len(uncached) == 0 {
+		return nil
+	}
+
+	missing, err := loadStoredBlocks(chain.ChainID, uncached)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(missing); start += blockHeaderBatchSize {
+		end := start + blockHeaderBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		if err := batchBlockHeaders(chain, missing[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadStoredBlocks caches the stored blocks among blockNumbers and returns the
+// numbers not stored yet.
+func loadStoredBlocks(chainID uint64, blockNumbers []uint64) ([]uint64, error) {
+	if !database.Enabled() {
+		return blockNumbers, nil
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	cursor, err := db.Collection(configs.MongoBlockCollection).Find(ctx, bson.M{"chainid": chainID, "number": bson.M{"$in": blockNumbers}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stored []*Block
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+
+	found := make(map[uint64]bool)
+	for _, block := range stored {
+		blocks.add(block)
+		found[block.Number] = true
+	}
+	blocks.record(0, uint64(len(stored)), 0)
+
+	var missing []uint64
+	for _, blockNumber := range blockNumbers {
+		if !found[blockNumber] {
+			missing = append(missing, blockNumber)
+		}
+	}
+
+	return missing, nil
+}
+
+func batchBlockHeaders(chain configs.Chain, blockNumbers []uint64) error {
+	headers := make([]*collectors.Header, len(blockNumbers))
+	batch := make([]rpc.BatchElem, len(blockNumbers))
+	for i, blockNumber := range blockNumbers {
+		batch[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(blockNumber), false},
+			Result: &headers[i],
+		}
+	}
+
+	err := rpcpool.HTTP(chain).Do(context.Background(), func(client rpcpool.Client) error {
+		return client.BatchCallContext(context.Background(), batch)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, elem := range batch {
+		if elem.Error != nil {
+			return fmt.Errorf("failed to get block header %v: %v", blockNumbers[i], elem.Error)
+		}
+		if headers[i] == nil {
+			return fmt.Errorf("block %v not found", blockNumbers[i])
+		}
+	}
+
+	blocks.record(0, 0, uint64(len(headers)))
+	saveBlocks(chain.ChainID, headers)
+
+	return nil
+}
+
+// ForgetBlock drops a block replaced by a reorg from the cache and the blocks
+// collection.
+func ForgetBlock(chainID uint64, blockNumber uint64) error {
+	blocks.remove(blockKey{chainID: chainID, blockNumber: blockNumber})
+	if !database.Enabled() {
+		return nil
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(configs.MongoBlockCollection).DeleteOne(context.Background(), bson.M{"chainid": chainID, "number": blockNumber})
+	return err
+}
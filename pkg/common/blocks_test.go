@@ -0,0 +1,48 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool/rpcpooltest"
+)
+
+func TestGetBlockTimeStamp(t *testing.T) {
+	client := &rpcpooltest.Client{Headers: map[uint64]*types.Header{
+		1: {Number: big.NewInt(1), Time: 1600000000},
+		2: {Number: big.NewInt(2), Time: 1600000012},
+		3: {Number: big.NewInt(3), Time: 1600000024},
+	}}
+	rpcpool.SetDialer(client.Dialer())
+
+	chain := configs.Chain{Name: "timestamps", ChainID: 1, RPCEndpoints: []string{"http://node"}}
+
+	tests := []struct {
+		blockNumber uint64
+		want        uint64
+		wantLookups int
+	}{
+		{blockNumber: 1, want: 1600000000, wantLookups: 1},
+		{blockNumber: 3, want: 1600000024, wantLookups: 2},
+		// Blocks already read are served from the cache.
+		{blockNumber: 1, want: 1600000000, wantLookups: 2},
+		{blockNumber: 2, want: 1600000012, wantLookups: 3},
+	}
+
+	for _, test := range tests {
+		got, err := GetBlockTimeStamp(chain, test.blockNumber)
+		if err != nil {
+			t.Fatalf("GetBlockTimeStamp(%v): %v", test.blockNumber, err)
+		}
+		if got != test.want {
+			t.Errorf("GetBlockTimeStamp(%v) = %v, want %v", test.blockNumber, got, test.want)
+		}
+		if client.HeaderLookups != test.wantLookups {
+			t.Errorf("after block %v got %v header lookups, want %v", test.blockNumber, client.HeaderLookups, test.wantLookups)
+		}
+	}
+}
@@ -2,132 +2,154 @@ package common
 
 import (
 	"context"
-	"errors"
-	"math/big"
-	"strings"
-	"sync"
-	"time"
 
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/junwei0117/logs-collector/contracts/token"
 	"github.com/junwei0117/logs-collector/pkg/configs"
 	"github.com/junwei0117/logs-collector/pkg/database"
-	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
-type TransferLog struct {
-	From            common.Address `json:"from"`
-	To              common.Address `json:"to"`
-	Value           *big.Int       `json:"value"`
-	ContractAddress common.Address `json:"contractAddress"`
-	BlockNumber     uint64         `json:"blockNumber"`
-	BlockHash       common.Hash    `json:"blockHash"`
-	TxHash          common.Hash    `json:"txHash"`
-	TxIndex         uint           `json:"txIndex"`
-	Index           uint           `json:"index"`
-	BlockTimeStamp  uint64         `json:"blockTimeStamp"`
-}
+type TransferLog = storage.TransferLog
 
-var blockTimeCache = struct {
-	sync.Mutex
-	m map[uint64]uint64
-}{
-	m: make(map[uint64]uint64),
-}
+// RemoveLogs deletes every event stored from vLog.
+func RemoveLogs(chainID uint64, vLog types.Log) error {
+	store, err := storage.GetStore()
+	if err != nil {
+		return err
+	}
 
-func GetBlockTimeStamp(blockNumber uint64) (uint64, error) {
-	blockTimeCache.Lock()
-	defer blockTimeCache.Unlock()
+	_, err = store.RemoveTransfers(context.Background(), chainID, vLog.TxHash, vLog.Index, vLog.BlockHash)
+	if err != nil || !database.Enabled() {
+		return err
+	}
 
-	if timestamp, ok := blockTimeCache.m[blockNumber]; ok {
-		return timestamp, nil
+	db, err := database.GetDB()
+	if err != nil {
+		return err
 	}
 
-	client, err := ethclient.DialContext(context.Background(), configs.RPCEndpoint)
+	filter := bson.M{"chainid": chainID, "txhash": vLog.TxHash, "index": vLog.Index, "blockhash": vLog.BlockHash}
+	for _, collection := range eventCollections() {
+		_, err = db.Collection(collection).DeleteMany(context.Background(), filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func RemoveOrphanedLogs(chainID uint64, blockNumber uint64, canonicalHash common.Hash) (int64, error) {
+	store, err := storage.GetStore()
 	if err != nil {
 		return 0, err
 	}
 
-	var block *types.Block
-	var retries = 3
-	var delay = time.Second * 1
+	removed, err := store.RemoveOrphanedTransfers(context.Background(), chainID, blockNumber, canonicalHash)
+	if err != nil || !database.Enabled() {
+		return removed, err
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		return removed, err
+	}
 
-	for retries > 0 {
-		block, err = client.BlockByNumber(context.Background(), new(big.Int).SetUint64(blockNumber))
-		if err == nil {
-			break
+	filter := bson.M{"chainid": chainID, "blocknumber": blockNumber, "blockhash": bson.M{"$ne": canonicalHash}}
+	for _, collection := range eventCollections() {
+		result, err := db.Collection(collection).DeleteMany(context.Background(), filter)
+		if err != nil {
+			return removed, err
 		}
+		removed += result.DeletedCount
+	}
+
+	return removed, nil
+}
 
-		logger.Logger.Warnf("failed to get block by number: %v. retrying in %v...", err, delay)
-		time.Sleep(delay)
-		retries--
+func isContractExcluded(address common.Address) bool {
+	for _, excluded := range configs.ExcludedContracts {
+		if address == excluded {
+			return true
+		}
 	}
+	return false
+}
 
-	if err != nil {
-		return 0, errors.New("failed to get block by number: " + err.Error())
+func IsContractIndexed(address common.Address) bool {
+	if isContractExcluded(address) {
+		return false
 	}
 
-	blockTime := block.Time()
-	blockTimeCache.m[blockNumber] = blockTime
+	if len(configs.Contracts) == 0 {
+		return true
+	}
 
-	return blockTime, nil
+	for _, contract := range configs.Contracts {
+		if address == contract {
+			return true
+		}
+	}
+	return false
 }
 
-func HandleTransferLogs(vLog types.Log) (*TransferLog, error) {
-	if len(vLog.Data) == 0 || len(vLog.Topics) > 3 {
+// buildTransferLogs decodes every transfer carried by vLog. ERC-1155
+// TransferBatch events yield one transfer per token id, told apart by BatchIndex.
+func buildTransferLogs(chain configs.Chain, vLog types.Log) ([]*TransferLog, error) {
+	if !IsContractIndexed(vLog.Address) {
 		return nil, nil
 	}
 
-	db, err := database.GetDB()
+	transferLogs, err := decodeTransferLogs(vLog)
 	if err != nil {
-		return nil, err
-	}
-
-	filter := bson.M{"txhash": vLog.TxHash, "index": vLog.Index}
-	count, err := db.Collection(configs.MongoCollection).CountDocuments(context.Background(), filter)
-	if err != nil {
-		return nil, err
+		skipUndecodable("transfer", vLog, err)
+		return nil, nil
 	}
-	if count > 0 {
-		logger.Logger.Debugf("transfer event already exists in MongoDB: %+v", vLog.TxHash)
+	if len(transferLogs) == 0 {
 		return nil, nil
 	}
 
-	transferLog := &TransferLog{}
-
-	contractAbi, err := abi.JSON(strings.NewReader(string(token.TokenABI)))
+	blockTimeStamp, err := GetBlockTimeStamp(chain, vLog.BlockNumber)
 	if err != nil {
 		return nil, err
 	}
 
-	err = contractAbi.UnpackIntoInterface(transferLog, "Transfer", vLog.Data)
-	if err != nil {
-		return nil, err
+	for _, transferLog := range transferLogs {
+		transferLog.ChainID = chain.ChainID
+		transferLog.ContractAddress = vLog.Address
+		transferLog.BlockNumber = vLog.BlockNumber
+		transferLog.BlockHash = vLog.BlockHash
+		transferLog.TxHash = vLog.TxHash
+		transferLog.TxIndex = vLog.TxIndex
+		transferLog.Index = vLog.Index
+		transferLog.BlockTimeStamp = blockTimeStamp
+		transferLog.Status = getTransferStatus(chain.ChainID, vLog.BlockNumber)
 	}
 
-	blockTimeStamp, err := GetBlockTimeStamp(vLog.BlockNumber)
+	return transferLogs, nil
+}
+
+// AssignChainID tags documents stored before multi-chain support with the chain
+// ID of the single chain they were collected from.
+func AssignChainID(chainID uint64) (int64, error) {
+	db, err := database.GetDB()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	transferLog.From = common.HexToAddress(vLog.Topics[1].Hex())
-	transferLog.To = common.HexToAddress(vLog.Topics[2].Hex())
-	transferLog.ContractAddress = vLog.Address
-	transferLog.BlockNumber = vLog.BlockNumber
-	transferLog.BlockHash = vLog.BlockHash
-	transferLog.TxHash = vLog.TxHash
-	transferLog.TxIndex = vLog.TxIndex
-	transferLog.Index = vLog.Index
-	transferLog.BlockTimeStamp = blockTimeStamp
+	var assigned int64
 
-	_, err = db.Collection(configs.MongoCollection).InsertOne(context.Background(), transferLog)
-	if err != nil {
-		return nil, err
+	filter := bson.M{"chainid": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"chainid": chainID}}
+	for _, collection := range append([]string{configs.MongoCollection}, eventCollections()...) {
+		result, err := db.Collection(collection).UpdateMany(context.Background(), filter, update)
+		if err != nil {
+			return assigned, err
+		}
+		assigned += result.ModifiedCount
 	}
 
-	return transferLog, nil
+	return assigned, nil
 }
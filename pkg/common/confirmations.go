@@ -0,0 +1,82 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+const (
+	TransferStatusPending   = storage.TransferStatusPending
+	TransferStatusConfirmed = storage.TransferStatusConfirmed
+)
+
+// confirmedBlocks holds, per chain ID, the highest block with at least
+// configs.Confirmations blocks built on top of it.
+var confirmedBlocks = struct {
+	sync.Mutex
+	m map[uint64]uint64
+}{
+	m: make(map[uint64]uint64),
+}
+
+func getTransferStatus(chainID uint64, blockNumber uint64) string {
+	if configs.Confirmations == 0 {
+		return TransferStatusConfirmed
+	}
+
+	confirmedBlocks.Lock()
+	defer confirmedBlocks.Unlock()
+
+	if confirmedBlock, ok := confirmedBlocks.m[chainID]; ok && blockNumber <= confirmedBlock {
+		return TransferStatusConfirmed
+	}
+	return TransferStatusPending
+}
+
+func PromoteConfirmedTransferLogs(chainID uint64, latestBlock uint64) (int64, error) {
+	if latestBlock < configs.Confirmations {
+		return 0, nil
+	}
+
+	confirmed := latestBlock - configs.Confirmations
+
+	confirmedBlocks.Lock()
+	if confirmed > confirmedBlocks.m[chainID] {
+		confirmedBlocks.m[chainID] = confirmed
+	}
+	confirmedBlocks.Unlock()
+
+	store, err := storage.GetStore()
+	if err != nil {
+		return 0, err
+	}
+
+	promoted, err := store.ConfirmTransfers(context.Background(), chainID, confirmed)
+	if err != nil || !database.Enabled() {
+		return promoted, err
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		return promoted, err
+	}
+
+	filter := bson.M{"chainid": chainID, "status": TransferStatusPending, "blocknumber": bson.M{"$lte": confirmed}}
+	update := bson.M{"$set": bson.M{"status": TransferStatusConfirmed}}
+
+	for _, collection := range eventCollections() {
+		result, err := db.Collection(collection).UpdateMany(context.Background(), filter, update)
+		if err != nil {
+			return promoted, err
+		}
+		promoted += result.ModifiedCount
+	}
+
+	return promoted, nil
+}
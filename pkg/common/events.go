@@ -0,0 +1,247 @@
+package common
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/junwei0117/logs-collector/contracts/token"
+	"github.com/junwei0117/logs-collector/pkg/collectors"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/events"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+)
+
+type ApprovalLog struct {
+	ChainID         uint64         `json:"chainId"`
+	Owner           common.Address `json:"owner"`
+	Spender         common.Address `json:"spender"`
+	Value           *big.Int       `json:"value"`
+	ContractAddress common.Address `json:"contractAddress"`
+	BlockNumber     uint64         `json:"blockNumber"`
+	BlockHash       common.Hash    `json:"blockHash"`
+	TxHash          common.Hash    `json:"txHash"`
+	TxIndex         uint           `json:"txIndex"`
+	Index           uint           `json:"index"`
+	BlockTimeStamp  uint64         `json:"blockTimeStamp"`
+	Status          string         `json:"status"`
+}
+
+type OwnershipTransferredLog struct {
+	ChainID         uint64         `json:"chainId"`
+	PreviousOwner   common.Address `json:"previousOwner"`
+	NewOwner        common.Address `json:"newOwner"`
+	ContractAddress common.Address `json:"contractAddress"`
+	BlockNumber     uint64         `json:"blockNumber"`
+	BlockHash       common.Hash    `json:"blockHash"`
+	TxHash          common.Hash    `json:"txHash"`
+	TxIndex         uint           `json:"txIndex"`
+	Index           uint           `json:"index"`
+	BlockTimeStamp  uint64         `json:"blockTimeStamp"`
+	Status          string         `json:"status"`
+}
+
+// EventLog is a log decoded through a definition registered in pkg/events.
+type EventLog struct {
+	ChainID         uint64                 `json:"chainId"`
+	Event           string                 `json:"event"`
+	Args            map[string]interface{} `json:"args"`
+	ContractAddress common.Address         `json:"contractAddress"`
+	BlockNumber     uint64                 `json:"blockNumber"`
+	BlockHash       common.Hash            `json:"blockHash"`
+	TxHash          common.Hash            `json:"txHash"`
+	TxIndex         uint                   `json:"txIndex"`
+	Index           uint                   `json:"index"`
+	BlockTimeStamp  uint64                 `json:"blockTimeStamp"`
+	Status          string                 `json:"status"`
+}
+
+var (
+	approvalTopic             = crypto.Keccak256Hash(collectors.Erc20ApprovalSig)
+	ownershipTransferredTopic = crypto.Keccak256Hash(collectors.OwnershipTransferredSig)
+)
+
+// eventCollections lists the MongoDB collections of every event but
+// transfers, which are kept by the storage backend.
+func eventCollections() []string {
+	collections := []string{configs.MongoApprovalCollection, configs.MongoOwnershipCollection}
+	return append(collections, events.Collections()...)
+}
+
+// buildLogs decodes vLog into the records of every event it carries, keyed by
+// the collection they are stored in. Transfers have no collection, they go to
+// the storage backend. Other events are only kept when MongoDB is configured.
+// Events that fail to decode are skipped; errors are only returned when the
+// block of vLog cannot be read.
+func buildLogs(chain configs.Chain, vLog types.Log) ([]pendingRecord, error) {
+	if len(vLog.Topics) == 0 {
+		return nil, nil
+	}
+
+	transferLogs, err := buildTransferLogs(chain, vLog)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []pendingRecord
+	for _, transferLog := range transferLogs {
+		records = append(records, pendingRecord{record: transferLog})
+	}
+
+	if !database.Enabled() {
+		return records, nil
+	}
+
+	eventLogs, err := buildEventLogs(chain, vLog)
+	if err != nil {
+		return nil, err
+	}
+	records = append(records, eventLogs...)
+
+	switch vLog.Topics[0] {
+	case approvalTopic:
+		approvalLog, err := buildApprovalLog(chain, vLog)
+		if err != nil {
+			return nil, err
+		}
+		if approvalLog != nil {
+			records = append(records, pendingRecord{collection: configs.MongoApprovalCollection, record: approvalLog})
+		}
+	case ownershipTransferredTopic:
+		ownershipLog, err := buildOwnershipTransferredLog(chain, vLog)
+		if err != nil {
+			return nil, err
+		}
+		if ownershipLog != nil {
+			records = append(records, pendingRecord{collection: configs.MongoOwnershipCollection, record: ownershipLog})
+		}
+	}
+
+	return records, nil
+}
+
+func buildApprovalLog(chain configs.Chain, vLog types.Log) (*ApprovalLog, error) {
+	if len(vLog.Topics) != 3 || len(vLog.Data) == 0 {
+		return nil, nil
+	}
+
+	if !IsContractIndexed(vLog.Address) {
+		return nil, nil
+	}
+
+	approvalLog := &ApprovalLog{}
+
+	contractAbi, err := abi.JSON(strings.NewReader(string(token.TokenABI)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = contractAbi.UnpackIntoInterface(approvalLog, "Approval", vLog.Data)
+	if err != nil {
+		skipUndecodable("Approval", vLog, err)
+		return nil, nil
+	}
+
+	blockTimeStamp, err := GetBlockTimeStamp(chain, vLog.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	approvalLog.Owner = common.HexToAddress(vLog.Topics[1].Hex())
+	approvalLog.Spender = common.HexToAddress(vLog.Topics[2].Hex())
+	approvalLog.ChainID = chain.ChainID
+	approvalLog.ContractAddress = vLog.Address
+	approvalLog.BlockNumber = vLog.BlockNumber
+	approvalLog.BlockHash = vLog.BlockHash
+	approvalLog.TxHash = vLog.TxHash
+	approvalLog.TxIndex = vLog.TxIndex
+	approvalLog.Index = vLog.Index
+	approvalLog.BlockTimeStamp = blockTimeStamp
+	approvalLog.Status = getTransferStatus(chain.ChainID, vLog.BlockNumber)
+
+	return approvalLog, nil
+}
+
+func buildOwnershipTransferredLog(chain configs.Chain, vLog types.Log) (*OwnershipTransferredLog, error) {
+	if len(vLog.Topics) != 3 {
+		return nil, nil
+	}
+
+	if !IsContractIndexed(vLog.Address) {
+		return nil, nil
+	}
+
+	blockTimeStamp, err := GetBlockTimeStamp(chain, vLog.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	ownershipLog := &OwnershipTransferredLog{
+		ChainID:         chain.ChainID,
+		PreviousOwner:   common.HexToAddress(vLog.Topics[1].Hex()),
+		NewOwner:        common.HexToAddress(vLog.Topics[2].Hex()),
+		ContractAddress: vLog.Address,
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash,
+		TxHash:          vLog.TxHash,
+		TxIndex:         vLog.TxIndex,
+		Index:           vLog.Index,
+		BlockTimeStamp:  blockTimeStamp,
+		Status:          getTransferStatus(chain.ChainID, vLog.BlockNumber),
+	}
+
+	return ownershipLog, nil
+}
+
+// buildEventLogs decodes vLog once for every registered definition it matches.
+// Registered events are independent of the built-in ones, so a log may be
+// stored by both.
+func buildEventLogs(chain configs.Chain, vLog types.Log) ([]pendingRecord, error) {
+	if isContractExcluded(vLog.Address) {
+		return nil, nil
+	}
+
+	var records []pendingRecord
+
+	for _, definition := range events.Match(vLog) {
+		args, err := definition.Decode(vLog)
+		if err != nil {
+			skipUndecodable(definition.Name, vLog, err)
+			continue
+		}
+
+		blockTimeStamp, err := GetBlockTimeStamp(chain, vLog.BlockNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		eventLog := &EventLog{
+			ChainID:         chain.ChainID,
+			Event:           definition.Name,
+			Args:            args,
+			ContractAddress: vLog.Address,
+			BlockNumber:     vLog.BlockNumber,
+			BlockHash:       vLog.BlockHash,
+			TxHash:          vLog.TxHash,
+			TxIndex:         vLog.TxIndex,
+			Index:           vLog.Index,
+			BlockTimeStamp:  blockTimeStamp,
+			Status:          getTransferStatus(chain.ChainID, vLog.BlockNumber),
+		}
+
+		records = append(records, pendingRecord{collection: definition.Collection, record: eventLog})
+	}
+
+	return records, nil
+}
+
+// skipUndecodable logs a malformed event, which would fail the same way on
+// every retry, so it does not hold back the rest of its batch.
+func skipUndecodable(event string, vLog types.Log, err error) {
+	logger.Logger.Warnf("[Collector] Skipping %s event %v of transaction %v in block %v that failed to decode: %v", event, vLog.Index, vLog.TxHash.Hex(), vLog.BlockNumber, err)
+}
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/junwei0117/logs-collector/pkg/collectors"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+// Number of transfers read per query while replaying balances.
+const replayPageSize = 1000
+
+// BalancesAt returns the balances account held on chain after blockNumber. It
+// starts from the latest snapshot and replays the transfers since, saving a
+// new snapshot on the way when the interval boundary is final.
+func BalancesAt(ctx context.Context, chain configs.Chain, account common.Address, blockNumber uint64) ([]*storage.Balance, error) {
+	store, err := storage.GetStore()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := store.GetBalanceSnapshot(ctx, chain.ChainID, account, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []*storage.Balance{}
+	var fromBlock uint64
+	if snapshot != nil {
+		if snapshot.Balances != nil {
+			balances = snapshot.Balances
+		}
+		fromBlock = snapshot.BlockNumber + 1
+	}
+
+	if interval := configs.BalanceSnapshotInterval; interval > 0 {
+		boundary := blockNumber / interval * interval
+
+		finalBlock, final, err := FinalBlock(ctx, chain.ChainID)
+		if err != nil {
+			return nil, err
+		}
+
+		if final && boundary >= fromBlock && boundary <= finalBlock {
+			balances, err = replayBalances(ctx, store, chain.ChainID, account, balances, fromBlock, boundary)
+			if err != nil {
+				return nil, err
+			}
+
+			err = store.SaveBalanceSnapshot(ctx, &storage.BalanceSnapshot{
+				ChainID:     chain.ChainID,
+				Account:     account,
+				BlockNumber: boundary,
+				Balances:    balances,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			fromBlock = boundary + 1
+		}
+	}
+
+	if fromBlock > blockNumber {
+		return balances, nil
+	}
+
+	return replayBalances(ctx, store, chain.ChainID, account, balances, fromBlock, blockNumber)
+}
+
+func replayBalances(ctx context.Context, store storage.Store, chainID uint64, account common.Address, balances []*storage.Balance, fromBlock uint64, toBlock uint64) ([]*storage.Balance, error) {
+	filter := storage.TransferFilter{
+		ChainID:   &chainID,
+		Address:   &account,
+		FromBlock: &fromBlock,
+		ToBlock:   &toBlock,
+	}
+
+	for offset := int64(0); ; offset += replayPageSize {
+		transfers, err := store.FindTransfers(ctx, filter, offset, replayPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		balances = storage.ApplyTransfers(balances, account, transfers)
+
+		if len(transfers) < replayPageSize {
+			return balances, nil
+		}
+	}
+}
+
+// FinalBlock returns the last block whose transfers are all stored and beyond
+// the reach of reorgs. Snapshots are only taken up to it. No block is final
+// until the main sync and the backfill of every contract in
+// configs.BackfillContracts saved a checkpoint.
+func FinalBlock(ctx context.Context, chainID uint64) (uint64, bool, error) {
+	store, err := storage.GetStore()
+	if err != nil {
+		return 0, false, err
+	}
+
+	collections := []string{configs.MongoCollection}
+	for _, contract := range configs.BackfillContracts {
+		if IsContractIndexed(contract) {
+			collections = append(collections, fmt.Sprintf("%s:%s", configs.MongoCollection, contract.Hex()))
+		}
+	}
+
+	var synced uint64
+	for i, collection := range collections {
+		checkpoint, err := store.GetCheckpoint(ctx, chainID, collection)
+		if err != nil || checkpoint == nil {
+			return 0, false, err
+		}
+		if i == 0 || checkpoint.BlockNumber < synced {
+			synced = checkpoint.BlockNumber
+		}
+	}
+
+	if synced < configs.ReorgDepth {
+		return 0, false, nil
+	}
+	return synced - configs.ReorgDepth, true, nil
+}
+
+// BlockAtTime returns the last block of chain mined at or before timestamp.
+func BlockAtTime(chain configs.Chain, timestamp uint64) (uint64, error) {
+	latestBlock, err := collectors.GetLatestBlockNumber(chain)
+	if err != nil {
+		return 0, err
+	}
+
+	latestTime, err := GetBlockTimeStamp(chain, uint64(latestBlock))
+	if err != nil {
+		return 0, err
+	}
+	if latestTime <= timestamp {
+		return uint64(latestBlock), nil
+	}
+
+	genesisTime, err := GetBlockTimeStamp(chain, 0)
+	if err != nil {
+		return 0, err
+	}
+	if genesisTime > timestamp {
+		return 0, fmt.Errorf("no block mined before %v", timestamp)
+	}
+
+	// Block timestamps only increase, so a binary search finds the last block
+	// at or before timestamp between genesis and the latest block.
+	low, high := uint64(0), uint64(latestBlock)
+	for low+1 < high {
+		middle := low + (high-low)/2
+
+		blockTime, err := GetBlockTimeStamp(chain, middle)
+		if err != nil {
+			return 0, err
+		}
+
+		if blockTime <= timestamp {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+
+	return low, nil
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+// Number of holders whose share of the supply is reported.
+const topHolders = 10
+
+// Distribution summarizes how the balances of a token are spread across its
+// holders.
+type Distribution struct {
+	Holders int64    `json:"holders"`
+	Total   *big.Int `json:"total"`
+	// TopShare is the fraction of the total held by the ten largest holders.
+	TopShare float64 `json:"topShare"`
+	// Gini is 0 when every holder holds the same amount and approaches 1 as
+	// a single holder owns everything.
+	Gini float64 `json:"gini"`
+}
+
+// HolderDistribution reads every positive balance matching filter, adding up
+// the balances of accounts that hold several token IDs of a collection.
+func HolderDistribution(ctx context.Context, filter storage.BalanceFilter) (*Distribution, error) {
+	store, err := storage.GetStore()
+	if err != nil {
+		return nil, err
+	}
+
+	filter.Positive = true
+
+	totals := make(map[common.Address]*big.Int)
+	for offset := int64(0); ; offset += replayPageSize {
+		balances, err := store.FindBalances(ctx, filter, offset, replayPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, balance := range balances {
+			total, ok := totals[balance.Account]
+			if !ok {
+				total = new(big.Int)
+				totals[balance.Account] = total
+			}
+			total.Add(total, balance.Balance)
+		}
+
+		if len(balances) < replayPageSize {
+			break
+		}
+	}
+
+	amounts := make([]*big.Int, 0, len(totals))
+	for _, total := range totals {
+		amounts = append(amounts, total)
+	}
+
+	return distributionOf(amounts), nil
+}
+
+// distributionOf summarizes the positive amounts held by each holder.
+func distributionOf(amounts []*big.Int) *Distribution {
+	sort.Slice(amounts, func(i, j int) bool { return amounts[i].Cmp(amounts[j]) < 0 })
+
+	distribution := &Distribution{Holders: int64(len(amounts)), Total: new(big.Int)}
+	if len(amounts) == 0 {
+		return distribution
+	}
+
+	// With the amounts x_1 <= ... <= x_n summing to S, the Gini coefficient
+	// is (2 * sum(i * x_i) - (n + 1) * S) / (n * S).
+	top := new(big.Int)
+	weighted := new(big.Int)
+	for i, amount := range amounts {
+		distribution.Total.Add(distribution.Total, amount)
+		weighted.Add(weighted, new(big.Int).Mul(big.NewInt(int64(i+1)), amount))
+		if i >= len(amounts)-topHolders {
+			top.Add(top, amount)
+		}
+	}
+
+	n := big.NewInt(int64(len(amounts)))
+	numerator := new(big.Int).Mul(weighted, big.NewInt(2))
+	numerator.Sub(numerator, new(big.Int).Mul(new(big.Int).Add(n, big.NewInt(1)), distribution.Total))
+	denominator := new(big.Int).Mul(n, distribution.Total)
+
+	distribution.TopShare, _ = new(big.Rat).SetFrac(top, distribution.Total).Float64()
+	distribution.Gini, _ = new(big.Rat).SetFrac(numerator, denominator).Float64()
+
+	return distribution
+}
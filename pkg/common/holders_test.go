@@ -0,0 +1,84 @@
+package common
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestDistributionOf(t *testing.T) {
+	// A thousand holders of a single token each, next to one holding almost
+	// the whole supply.
+	concentrated := []*big.Int{new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil)}
+	for i := 0; i < 1000; i++ {
+		concentrated = append(concentrated, big.NewInt(1))
+	}
+
+	tests := []struct {
+		name     string
+		amounts  []*big.Int
+		holders  int64
+		total    int64
+		gini     float64
+		topShare float64
+	}{
+		{
+			name:    "no holders",
+			amounts: nil,
+		},
+		{
+			name:     "single holder",
+			amounts:  []*big.Int{big.NewInt(100)},
+			holders:  1,
+			total:    100,
+			gini:     0,
+			topShare: 1,
+		},
+		{
+			name:     "equal holders",
+			amounts:  []*big.Int{big.NewInt(5), big.NewInt(5), big.NewInt(5), big.NewInt(5)},
+			holders:  4,
+			total:    20,
+			gini:     0,
+			topShare: 1,
+		},
+		{
+			name:     "unequal holders",
+			amounts:  []*big.Int{big.NewInt(4), big.NewInt(1), big.NewInt(2), big.NewInt(1)},
+			holders:  4,
+			total:    8,
+			gini:     0.3125,
+			topShare: 1,
+		},
+		{
+			name:     "one holder owns nearly everything",
+			amounts:  concentrated,
+			holders:  1001,
+			total:    -1,
+			gini:     1000.0 / 1001,
+			topShare: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			distribution := distributionOf(test.amounts)
+
+			if distribution.Holders != test.holders {
+				t.Errorf("got %v holders, want %v", distribution.Holders, test.holders)
+			}
+			if test.total >= 0 && distribution.Total.Int64() != test.total {
+				t.Errorf("got total %v, want %v", distribution.Total, test.total)
+			}
+			if test.holders == 0 {
+				return
+			}
+			if math.Abs(distribution.Gini-test.gini) > 1e-6 {
+				t.Errorf("got Gini %v, want %v", distribution.Gini, test.gini)
+			}
+			if math.Abs(distribution.TopShare-test.topShare) > 1e-6 {
+				t.Errorf("got top share %v, want %v", distribution.TopShare, test.topShare)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+)
+
+// CreateIndexes creates the unique indexes ingestion relies on to reject
+// duplicates in the MongoDB collections. Transfers are indexed by the storage
+// backend.
+func CreateIndexes() error {
+	if !database.Enabled() {
+		return nil
+	}
+
+	err := database.CreateUniqueIndex(configs.MongoBlockCollection, bson.D{{Key: "chainid", Value: 1}, {Key: "number", Value: 1}})
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range eventCollections() {
+		err := database.CreateUniqueIndex(collection, bson.D{{Key: "chainid", Value: 1}, {Key: "txhash", Value: 1}, {Key: "index", Value: 1}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+// pendingRecord is a decoded event waiting to be written to its collection.
+// Transfers leave the collection empty.
+type pendingRecord struct {
+	collection string
+	record     interface{}
+}
+
+// HandleLogs stores vLog in the collection of the event it carries and returns
+// the stored records.
+func HandleLogs(chain configs.Chain, vLog types.Log) ([]interface{}, error) {
+	return HandleLogBatch(chain, []types.Log{vLog})
+}
+
+// HandleLogBatch decodes logs and writes the records in bulk, transfers to the
+// storage backend and other events with one unordered InsertMany per
+// collection. Records stored before are rejected by the unique indexes and
+// left out of the result. Logs that fail to decode are skipped, so only
+// storage errors and blocks that cannot be read fail the batch.
+func HandleLogBatch(chain configs.Chain, logs []types.Log) ([]interface{}, error) {
+	var pending []pendingRecord
+	for _, vLog := range logs {
+		records, err := buildLogs(chain, vLog)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, records...)
+	}
+
+	var transferLogs []*TransferLog
+	var events []pendingRecord
+	for _, record := range pending {
+		if transferLog, ok := record.record.(*TransferLog); ok {
+			transferLogs = append(transferLogs, transferLog)
+		} else {
+			events = append(events, record)
+		}
+	}
+
+	stored, err := insertTransferLogs(transferLogs)
+	if err != nil {
+		return stored, err
+	}
+
+	storedEvents, err := insertRecords(events)
+	return append(stored, storedEvents...), err
+}
+
+func insertTransferLogs(transferLogs []*TransferLog) ([]interface{}, error) {
+	if len(transferLogs) == 0 {
+		return nil, nil
+	}
+
+	store, err := storage.GetStore()
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := store.InsertTransfers(context.Background(), transferLogs)
+	if err != nil {
+		return nil, err
+	}
+	if duplicates := len(transferLogs) - len(inserted); duplicates > 0 {
+		logger.Logger.Debugf("%v transfer events already exist", duplicates)
+	}
+
+	stored := make([]interface{}, len(inserted))
+	for i, transferLog := range inserted {
+		stored[i] = transferLog
+	}
+
+	return stored, nil
+}
+
+func insertRecords(pending []pendingRecord) ([]interface{}, error) {
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []string
+	documents := make(map[string][]interface{})
+	for _, record := range pending {
+		if _, ok := documents[record.collection]; !ok {
+			collections = append(collections, record.collection)
+		}
+		documents[record.collection] = append(documents[record.collection], record.record)
+	}
+
+	var stored []interface{}
+
+	for _, collection := range collections {
+		_, err := db.Collection(collection).InsertMany(context.Background(), documents[collection], options.InsertMany().SetOrdered(false))
+
+		duplicates, err := database.DuplicateIndexes(err)
+		if err != nil {
+			return stored, err
+		}
+		if len(duplicates) > 0 {
+			logger.Logger.Debugf("%v events already exist in %s", len(duplicates), collection)
+		}
+
+		for i, document := range documents[collection] {
+			if !duplicates[i] {
+				stored = append(stored, document)
+			}
+		}
+	}
+
+	return stored, nil
+}
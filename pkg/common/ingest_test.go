@@ -0,0 +1,81 @@
+package common
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool/rpcpooltest"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+func TestHandleLogBatchSkipsUndecodableLogs(t *testing.T) {
+	configs.Storage = "sqlite"
+	configs.SQLitePath = filepath.Join(t.TempDir(), "ingest.db")
+	if err := storage.Open(); err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+
+	client := &rpcpooltest.Client{Headers: map[uint64]*types.Header{
+		20: {Number: big.NewInt(20), Time: 1600000000},
+	}}
+	rpcpool.SetDialer(client.Dialer())
+	chain := configs.Chain{Name: "ingest", ChainID: 1, RPCEndpoints: []string{"http://node"}}
+
+	token := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	from := common.HexToAddress("0x0000000000000000000000000000000000000001").Hash()
+	to := common.HexToAddress("0x0000000000000000000000000000000000000002").Hash()
+
+	logs := []types.Log{
+		{
+			Address:     token,
+			Topics:      []common.Hash{transferTopic, from, to},
+			Data:        common.LeftPadBytes(big.NewInt(500).Bytes(), 32),
+			BlockNumber: 20,
+			TxHash:      common.HexToHash("0x01"),
+			Index:       0,
+		},
+		{
+			// An ERC-20 Transfer whose value is cut short.
+			Address:     token,
+			Topics:      []common.Hash{transferTopic, from, to},
+			Data:        []byte{0x01, 0x02},
+			BlockNumber: 20,
+			TxHash:      common.HexToHash("0x02"),
+			Index:       1,
+		},
+		{
+			// A TransferBatch with mismatched ids and values.
+			Address:     token,
+			Topics:      []common.Hash{transferBatchTopic, from, from, to},
+			Data:        common.FromHex("0x" + "0000000000000000000000000000000000000000000000000000000000000040" + "0000000000000000000000000000000000000000000000000000000000000080" + "0000000000000000000000000000000000000000000000000000000000000001" + "0000000000000000000000000000000000000000000000000000000000000007" + "0000000000000000000000000000000000000000000000000000000000000000"),
+			BlockNumber: 20,
+			TxHash:      common.HexToHash("0x03"),
+			Index:       2,
+		},
+	}
+
+	records, err := HandleLogBatch(chain, logs)
+	if err != nil {
+		t.Fatalf("HandleLogBatch: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("stored %v records, want 1", len(records))
+	}
+
+	transferLog, ok := records[0].(*TransferLog)
+	if !ok {
+		t.Fatalf("stored %T, want a transfer", records[0])
+	}
+	if transferLog.Value.Int64() != 500 || transferLog.TxHash != logs[0].TxHash {
+		t.Errorf("stored transfer of %v in %v, want 500 in %v", transferLog.Value, transferLog.TxHash.Hex(), logs[0].TxHash.Hex())
+	}
+	if transferLog.BlockTimeStamp != 1600000000 {
+		t.Errorf("stored block timestamp %v, want 1600000000", transferLog.BlockTimeStamp)
+	}
+}
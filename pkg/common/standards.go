@@ -0,0 +1,154 @@
+package common
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/junwei0117/logs-collector/contracts/token"
+	"github.com/junwei0117/logs-collector/pkg/collectors"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+const (
+	TokenStandardERC20   = storage.TokenStandardERC20
+	TokenStandardERC721  = storage.TokenStandardERC721
+	TokenStandardERC1155 = storage.TokenStandardERC1155
+)
+
+const erc1155ABI = `[
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"operator","type":"address"},{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"id","type":"uint256"},{"indexed":false,"name":"value","type":"uint256"}],"name":"TransferSingle","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"operator","type":"address"},{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"ids","type":"uint256[]"},{"indexed":false,"name":"values","type":"uint256[]"}],"name":"TransferBatch","type":"event"}
+]`
+
+var (
+	transferTopic       = crypto.Keccak256Hash(collectors.Erc20TransferSig)
+	transferSingleTopic = crypto.Keccak256Hash(collectors.Erc1155TransferSingleSig)
+	transferBatchTopic  = crypto.Keccak256Hash(collectors.Erc1155TransferBatchSig)
+)
+
+// decodeTransferLogs returns nil for logs that share a transfer topic but do
+// not match the layout of any supported token standard.
+func decodeTransferLogs(vLog types.Log) ([]*TransferLog, error) {
+	if len(vLog.Topics) == 0 {
+		return nil, nil
+	}
+
+	switch vLog.Topics[0] {
+	case transferTopic:
+		if len(vLog.Topics) == 3 && len(vLog.Data) > 0 {
+			return decodeErc20Transfer(vLog)
+		}
+		if len(vLog.Topics) == 4 && len(vLog.Data) == 0 {
+			return decodeErc721Transfer(vLog)
+		}
+	case transferSingleTopic:
+		if len(vLog.Topics) == 4 {
+			return decodeErc1155TransferSingle(vLog)
+		}
+	case transferBatchTopic:
+		if len(vLog.Topics) == 4 {
+			return decodeErc1155TransferBatch(vLog)
+		}
+	}
+
+	return nil, nil
+}
+
+func decodeErc20Transfer(vLog types.Log) ([]*TransferLog, error) {
+	transferLog := &TransferLog{}
+
+	contractAbi, err := abi.JSON(strings.NewReader(string(token.TokenABI)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = contractAbi.UnpackIntoInterface(transferLog, "Transfer", vLog.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	transferLog.Standard = TokenStandardERC20
+	transferLog.From = common.HexToAddress(vLog.Topics[1].Hex())
+	transferLog.To = common.HexToAddress(vLog.Topics[2].Hex())
+
+	return []*TransferLog{transferLog}, nil
+}
+
+func decodeErc721Transfer(vLog types.Log) ([]*TransferLog, error) {
+	transferLog := &TransferLog{
+		Standard: TokenStandardERC721,
+		From:     common.HexToAddress(vLog.Topics[1].Hex()),
+		To:       common.HexToAddress(vLog.Topics[2].Hex()),
+		TokenID:  vLog.Topics[3].Big(),
+		Value:    big.NewInt(1),
+	}
+
+	return []*TransferLog{transferLog}, nil
+}
+
+func decodeErc1155TransferSingle(vLog types.Log) ([]*TransferLog, error) {
+	contractAbi, err := abi.JSON(strings.NewReader(erc1155ABI))
+	if err != nil {
+		return nil, err
+	}
+
+	event := struct {
+		Id    *big.Int
+		Value *big.Int
+	}{}
+	err = contractAbi.UnpackIntoInterface(&event, "TransferSingle", vLog.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	transferLog := &TransferLog{
+		Standard: TokenStandardERC1155,
+		Operator: common.HexToAddress(vLog.Topics[1].Hex()),
+		From:     common.HexToAddress(vLog.Topics[2].Hex()),
+		To:       common.HexToAddress(vLog.Topics[3].Hex()),
+		TokenID:  event.Id,
+		Value:    event.Value,
+	}
+
+	return []*TransferLog{transferLog}, nil
+}
+
+func decodeErc1155TransferBatch(vLog types.Log) ([]*TransferLog, error) {
+	contractAbi, err := abi.JSON(strings.NewReader(erc1155ABI))
+	if err != nil {
+		return nil, err
+	}
+
+	event := struct {
+		Ids    []*big.Int
+		Values []*big.Int
+	}{}
+	err = contractAbi.UnpackIntoInterface(&event, "TransferBatch", vLog.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(event.Ids) != len(event.Values) {
+		return nil, errors.New("mismatched ids and values in TransferBatch event")
+	}
+
+	var transferLogs []*TransferLog
+	for i := range event.Ids {
+		transferLogs = append(transferLogs, &TransferLog{
+			Standard:   TokenStandardERC1155,
+			Operator:   common.HexToAddress(vLog.Topics[1].Hex()),
+			From:       common.HexToAddress(vLog.Topics[2].Hex()),
+			To:         common.HexToAddress(vLog.Topics[3].Hex()),
+			TokenID:    event.Ids[i],
+			Value:      event.Values[i],
+			BatchIndex: uint(i),
+		})
+	}
+
+	return transferLogs, nil
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func word(value int64) []byte {
+	return common.LeftPadBytes(big.NewInt(value).Bytes(), 32)
+}
+
+func words(values ...int64) []byte {
+	var data []byte
+	for _, value := range values {
+		data = append(data, word(value)...)
+	}
+	return data
+}
+
+func TestDecodeTransferLogs(t *testing.T) {
+	operator := common.HexToAddress("0x0000000000000000000000000000000000000009")
+	from := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	to := common.HexToAddress("0x0000000000000000000000000000000000000002")
+
+	tests := []struct {
+		name    string
+		topics  []common.Hash
+		data    []byte
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "erc-20 transfer",
+			topics: []common.Hash{transferTopic, from.Hash(), to.Hash()},
+			data:   word(500),
+			want:   []string{"erc20 id=<nil> value=500 batch=0"},
+		},
+		{
+			name:   "erc-721 transfer",
+			topics: []common.Hash{transferTopic, from.Hash(), to.Hash(), common.BigToHash(big.NewInt(7))},
+			want:   []string{"erc721 id=7 value=1 batch=0"},
+		},
+		{
+			name:   "erc-1155 single",
+			topics: []common.Hash{transferSingleTopic, operator.Hash(), from.Hash(), to.Hash()},
+			data:   words(3, 10),
+			want:   []string{"erc1155 id=3 value=10 batch=0"},
+		},
+		{
+			// Both arrays are encoded after their offsets: ids at 0x40 and
+			// values at 0xa0.
+			name:   "erc-1155 batch",
+			topics: []common.Hash{transferBatchTopic, operator.Hash(), from.Hash(), to.Hash()},
+			data:   words(0x40, 0xa0, 2, 1, 2, 2, 5, 6),
+			want:   []string{"erc1155 id=1 value=5 batch=0", "erc1155 id=2 value=6 batch=1"},
+		},
+		{
+			name:    "erc-1155 batch with mismatched ids and values",
+			topics:  []common.Hash{transferBatchTopic, operator.Hash(), from.Hash(), to.Hash()},
+			data:    words(0x40, 0x80, 1, 7, 0),
+			wantErr: true,
+		},
+		{
+			name:    "erc-20 transfer cut short",
+			topics:  []common.Hash{transferTopic, from.Hash(), to.Hash()},
+			data:    []byte{0x01, 0x02},
+			wantErr: true,
+		},
+		{
+			name:   "transfer without value",
+			topics: []common.Hash{transferTopic, from.Hash(), to.Hash()},
+		},
+		{
+			name:   "other event",
+			topics: []common.Hash{approvalTopic, from.Hash(), to.Hash()},
+			data:   word(500),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transferLogs, err := decodeTransferLogs(types.Log{Topics: test.topics, Data: test.data})
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []string
+			for _, transferLog := range transferLogs {
+				if transferLog.From != from || transferLog.To != to {
+					t.Errorf("got transfer from %v to %v, want from %v to %v", transferLog.From.Hex(), transferLog.To.Hex(), from.Hex(), to.Hex())
+				}
+				if transferLog.Standard == TokenStandardERC1155 && transferLog.Operator != operator {
+					t.Errorf("got operator %v, want %v", transferLog.Operator.Hex(), operator.Hex())
+				}
+				got = append(got, fmt.Sprintf("%s id=%v value=%v batch=%v", transferLog.Standard, transferLog.TokenID, transferLog.Value, transferLog.BatchIndex))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
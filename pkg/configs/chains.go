@@ -0,0 +1,101 @@
+package configs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Chain describes one network indexed by the process. A zero ChainID is
+// resolved from the RPC endpoint at startup. The endpoint lists add fallback
+// providers to the single endpoints.
+type Chain struct {
+	Name                  string   `json:"name"`
+	ChainID               uint64   `json:"chainId"`
+	RPCEndpoint           string   `json:"rpcEndpoint"`
+	RPCEndpoints          []string `json:"rpcEndpoints"`
+	WebsocketRPCEndpoint  string   `json:"websocketRPCEndpoint"`
+	WebsocketRPCEndpoints []string `json:"websocketRPCEndpoints"`
+	FromBlock             int64    `json:"fromBlock"`
+}
+
+func (chain Chain) HTTPEndpoints() []string {
+	return mergeEndpoints(chain.RPCEndpoint, chain.RPCEndpoints)
+}
+
+func (chain Chain) WebsocketEndpoints() []string {
+	return mergeEndpoints(chain.WebsocketRPCEndpoint, chain.WebsocketRPCEndpoints)
+}
+
+func mergeEndpoints(endpoint string, endpoints []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, url := range append([]string{endpoint}, endpoints...) {
+		url = strings.TrimSpace(url)
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+		merged = append(merged, url)
+	}
+	return merged
+}
+
+func splitEndpoints(value string) []string {
+	return mergeEndpoints("", strings.Split(value, ","))
+}
+
+func loadChains(path string) ([]Chain, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var chains []Chain
+	if err := json.Unmarshal(content, &chains); err != nil {
+		return nil, err
+	}
+
+	if len(chains) == 0 {
+		return nil, fmt.Errorf("no chains configured in %s", path)
+	}
+
+	names := make(map[string]bool)
+	for _, chain := range chains {
+		if chain.Name == "" || len(chain.HTTPEndpoints()) == 0 || len(chain.WebsocketEndpoints()) == 0 {
+			return nil, fmt.Errorf("chain requires a name and at least one rpcEndpoint and websocketRPCEndpoint: %s", chain.Name)
+		}
+		if names[chain.Name] {
+			return nil, fmt.Errorf("duplicate chain %q", chain.Name)
+		}
+		names[chain.Name] = true
+	}
+
+	return chains, nil
+}
+
+// CheckChainIDs rejects chains resolved to the same chain ID, which would
+// share their checkpoints and stored events.
+func CheckChainIDs() error {
+	names := make(map[uint64]string)
+	for _, chain := range Chains {
+		if name, ok := names[chain.ChainID]; ok {
+			return fmt.Errorf("chains %q and %q have the same chain ID %v", name, chain.Name, chain.ChainID)
+		}
+		names[chain.ChainID] = chain.Name
+	}
+	return nil
+}
+
+// GetChain looks a chain up by its name or chain ID.
+func GetChain(nameOrID string) (Chain, bool) {
+	chainID, err := strconv.ParseUint(nameOrID, 10, 64)
+	for _, chain := range Chains {
+		if chain.Name == nameOrID || err == nil && chain.ChainID == chainID {
+			return chain, true
+		}
+	}
+	return Chain{}, false
+}
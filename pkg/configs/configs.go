@@ -2,40 +2,178 @@ package configs
 
 import (
 	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	RPCEndpoint                string
+	WebsocketRPCEndpoint       string
+	FromBlock                  int64
+	Chains                     []Chain
+	CollectorsWorks            int
+	CollectorsBlockRange       int64
+	CollectorsMaxBlockRange    int64
+	CollectorsRetries          int
+	Contracts                  []common.Address
+	ExcludedContracts          []common.Address
+	BackfillContracts          []common.Address
+	EventsConfig               string
+	Storage                    string
+	PostgresDSN                string
+	SQLitePath                 string
+	MongoEndpoint              string
+	MongoDatabase              string
+	MongoCollection            string
+	MongoCheckpointCollection  string
+	MongoApprovalCollection    string
+	MongoOwnershipCollection   string
+	MongoBlockCollection       string
+	MongoTokenCollection       string
+	MongoBalanceCollection     string
+	MongoSnapshotCollection    string
+	MongoDiscrepancyCollection string
+	BlockCacheSize             int
+	Rescan                     bool
+	CheckpointInterval         int64
+	ReorgDepth                 uint64
+	Confirmations              uint64
+	BalanceSnapshotInterval    uint64
+	ReconciliationInterval     time.Duration
+	ReconciliationSampleSize   int64
+	Debug                      bool
+	ReportCaller               bool
 )
 
+// Flags are registered up front so their defaults apply until Parse is called,
+// such as in tests.
 var (
-	RPCEndpoint          string
-	WebsocketRPCEndpoint string
-	FromBlock            int64
-	CollectorsWorks      int
-	MongoEndpoint        string
-	MongoDatabase        string
-	MongoCollection      string
-	Debug                bool
-	ReportCaller         bool
+	rpcEndpoint                = flag.String("rpcEndpoint", "", "JSON-RPC endpoint URL, comma-separated for fallback providers")
+	wsEndpoint                 = flag.String("websocketRPCEndpoint", "", "WebSocket JSON-RPC endpoint URL, comma-separated for fallback providers")
+	fromBlock                  = flag.Int64("fromBlock", 0, "Starting block number")
+	chainsConfig               = flag.String("chainsConfig", "", "Path to a JSON file listing the chains to index, overrides the single chain endpoint flags")
+	collectorsWorks            = flag.Int("collectorsWorks", 0, "Number of workers for collectors")
+	collectorsBlockRange       = flag.Int64("collectorsBlockRange", 2000, "Initial number of blocks per eth_getLogs request")
+	collectorsMaxBlockRange    = flag.Int64("collectorsMaxBlockRange", 20000, "Maximum number of blocks per eth_getLogs request")
+	collectorsRetries          = flag.Int("collectorsRetries", 3, "Number of retries for a failed eth_getLogs request")
+	contracts                  = flag.String("contracts", "", "Comma-separated contract addresses to index, all contracts if empty")
+	excludedContracts          = flag.String("excludedContracts", "", "Comma-separated contract addresses to skip")
+	backfillContracts          = flag.String("backfillContracts", "", "Comma-separated contract addresses whose history is backfilled from fromBlock")
+	eventsConfig               = flag.String("eventsConfig", "", "Path to a JSON file registering additional contract events to index")
+	storage                    = flag.String("storage", "mongo", "Storage backend for transfers and checkpoints: mongo, postgres or sqlite")
+	postgresDSN                = flag.String("postgresDSN", "", "PostgreSQL connection string, used with --storage=postgres")
+	sqlitePath                 = flag.String("sqlitePath", "logs-collector.db", "SQLite database file, used with --storage=sqlite")
+	mongoEndpoint              = flag.String("mongoEndpoint", "", "MongoDB endpoint URL")
+	mongoDatabase              = flag.String("mongoDatabase", "", "MongoDB database name")
+	mongoCollection            = flag.String("mongoCollection", "", "MongoDB collection name")
+	mongoCheckpointCollection  = flag.String("mongoCheckpointCollection", "checkpoints", "MongoDB collection name for sync checkpoints")
+	mongoApprovalCollection    = flag.String("mongoApprovalCollection", "approvals", "MongoDB collection name for approval events")
+	mongoOwnershipCollection   = flag.String("mongoOwnershipCollection", "ownershipTransfers", "MongoDB collection name for ownership transfer events")
+	mongoBlockCollection       = flag.String("mongoBlockCollection", "blocks", "MongoDB collection name for block hashes and timestamps")
+	mongoTokenCollection       = flag.String("mongoTokenCollection", "tokens", "MongoDB collection name for token metadata")
+	mongoBalanceCollection     = flag.String("mongoBalanceCollection", "balances", "MongoDB collection name for account balances")
+	mongoSnapshotCollection    = flag.String("mongoSnapshotCollection", "balanceSnapshots", "MongoDB collection name for historical balance snapshots")
+	mongoDiscrepancyCollection = flag.String("mongoDiscrepancyCollection", "discrepancies", "MongoDB collection name for balance reconciliation discrepancies")
+	blockCacheSize             = flag.Int("blockCacheSize", 100000, "Maximum number of blocks kept in the in-memory block cache")
+	rescan                     = flag.Bool("rescan", false, "Ignore the stored checkpoint and rescan from fromBlock")
+	checkpointInterval         = flag.Int64("checkpointInterval", 10000, "Number of blocks processed between checkpoints")
+	reorgDepth                 = flag.Uint64("reorgDepth", 64, "Number of recent blocks tracked for chain reorganizations")
+	confirmations              = flag.Uint64("confirmations", 0, "Number of blocks built on top before a transfer is confirmed")
+	reconciliationInterval     = flag.Duration("reconciliationInterval", time.Hour, "Time between reconciliations of indexed balances against balanceOf and totalSupply, 0 to disable")
+	reconciliationSampleSize   = flag.Int64("reconciliationSampleSize", 50, "Number of balances sampled per reconciliation")
+	balanceSnapshotInterval    = flag.Uint64("balanceSnapshotInterval", 10000, "Number of blocks between the snapshots historical balances are replayed from, 0 to disable snapshots")
+	debug                      = flag.Bool("debug", false, "Enable debug mode")
+	reportCaller               = flag.Bool("reportCaller", false, "Enable log report caller")
 )
 
 func init() {
-	rpcEndpoint := flag.String("rpcEndpoint", "", "JSON-RPC endpoint URL")
-	wsEndpoint := flag.String("websocketRPCEndpoint", "", "WebSocket JSON-RPC endpoint URL")
-	fromBlock := flag.Int64("fromBlock", 0, "Starting block number")
-	collectorsWorks := flag.Int("collectorsWorks", 0, "Number of workers for collectors")
-	mongoEndpoint := flag.String("mongoEndpoint", "", "MongoDB endpoint URL")
-	mongoDatabase := flag.String("mongoDatabase", "", "MongoDB database name")
-	mongoCollection := flag.String("mongoCollection", "", "MongoDB collection name")
-	debug := flag.Bool("debug", false, "Enable debug mode")
-	reportCaller := flag.Bool("reportCaller", false, "Enable log report caller")
+	apply()
+}
 
+// Parse reads the command line flags. main calls it before anything else.
+func Parse() {
 	flag.Parse()
+	apply()
+}
 
-	RPCEndpoint = *rpcEndpoint
-	WebsocketRPCEndpoint = *wsEndpoint
+func apply() {
+	rpcEndpoints := splitEndpoints(*rpcEndpoint)
+	if len(rpcEndpoints) > 0 {
+		RPCEndpoint = rpcEndpoints[0]
+	}
+	wsEndpoints := splitEndpoints(*wsEndpoint)
+	if len(wsEndpoints) > 0 {
+		WebsocketRPCEndpoint = wsEndpoints[0]
+	}
 	FromBlock = *fromBlock
+	if *chainsConfig != "" {
+		chains, err := loadChains(*chainsConfig)
+		if err != nil {
+			log.Fatalf("Invalid chains config: %v", err)
+		}
+		Chains = chains
+	} else {
+		Chains = []Chain{{
+			Name:                  "default",
+			RPCEndpoints:          rpcEndpoints,
+			WebsocketRPCEndpoints: wsEndpoints,
+			FromBlock:             FromBlock,
+		}}
+	}
 	CollectorsWorks = *collectorsWorks
+	CollectorsBlockRange = *collectorsBlockRange
+	if CollectorsBlockRange < 1 {
+		log.Fatalf("Invalid collectorsBlockRange %v, must be at least 1", CollectorsBlockRange)
+	}
+	CollectorsMaxBlockRange = *collectorsMaxBlockRange
+	CollectorsRetries = *collectorsRetries
+	Contracts = parseAddresses("contracts", *contracts)
+	ExcludedContracts = parseAddresses("excludedContracts", *excludedContracts)
+	BackfillContracts = parseAddresses("backfillContracts", *backfillContracts)
+	EventsConfig = *eventsConfig
+	Storage = *storage
+	PostgresDSN = *postgresDSN
+	SQLitePath = *sqlitePath
 	MongoEndpoint = *mongoEndpoint
 	MongoDatabase = *mongoDatabase
 	MongoCollection = *mongoCollection
+	MongoCheckpointCollection = *mongoCheckpointCollection
+	MongoApprovalCollection = *mongoApprovalCollection
+	MongoOwnershipCollection = *mongoOwnershipCollection
+	MongoBlockCollection = *mongoBlockCollection
+	MongoTokenCollection = *mongoTokenCollection
+	MongoBalanceCollection = *mongoBalanceCollection
+	MongoSnapshotCollection = *mongoSnapshotCollection
+	MongoDiscrepancyCollection = *mongoDiscrepancyCollection
+	BlockCacheSize = *blockCacheSize
+	Rescan = *rescan
+	CheckpointInterval = *checkpointInterval
+	if CheckpointInterval < 1 {
+		log.Fatalf("Invalid checkpointInterval %v, must be at least 1", CheckpointInterval)
+	}
+	ReorgDepth = *reorgDepth
+	Confirmations = *confirmations
+	BalanceSnapshotInterval = *balanceSnapshotInterval
+	ReconciliationInterval = *reconciliationInterval
+	ReconciliationSampleSize = *reconciliationSampleSize
 	Debug = *debug
 	ReportCaller = *reportCaller
 }
+
+func parseAddresses(name string, value string) []common.Address {
+	var addresses []common.Address
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if !common.IsHexAddress(address) {
+			log.Fatalf("Invalid address %q in %s", address, name)
+		}
+		addresses = append(addresses, common.HexToAddress(address))
+	}
+	return addresses
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+var bigIntType = reflect.TypeOf(&big.Int{})
+
+// newRegistry stores *big.Int values as decimal strings. The default codec
+// writes them as empty documents, losing the value.
+func newRegistry() *bsoncodec.Registry {
+	return bson.NewRegistryBuilder().
+		RegisterTypeEncoder(bigIntType, bsoncodec.ValueEncoderFunc(encodeBigInt)).
+		RegisterTypeDecoder(bigIntType, bsoncodec.ValueDecoderFunc(decodeBigInt)).
+		Build()
+}
+
+func encodeBigInt(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if !val.IsValid() || val.Type() != bigIntType {
+		return bsoncodec.ValueEncoderError{Name: "encodeBigInt", Types: []reflect.Type{bigIntType}, Received: val}
+	}
+	if val.IsNil() {
+		return vw.WriteNull()
+	}
+	return vw.WriteString(val.Interface().(*big.Int).String())
+}
+
+func decodeBigInt(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Type() != bigIntType {
+		return bsoncodec.ValueDecoderError{Name: "decodeBigInt", Types: []reflect.Type{bigIntType}, Received: val}
+	}
+
+	switch vr.Type() {
+	case bsontype.String:
+		str, err := vr.ReadString()
+		if err != nil {
+			return err
+		}
+		value, ok := new(big.Int).SetString(str, 10)
+		if !ok {
+			return fmt.Errorf("invalid big integer %q", str)
+		}
+		val.Set(reflect.ValueOf(value))
+	case bsontype.Null:
+		val.Set(reflect.Zero(bigIntType))
+		return vr.ReadNull()
+	case bsontype.EmbeddedDocument:
+		// Values written before the codec was registered are empty documents.
+		val.Set(reflect.ValueOf(new(big.Int)))
+		return vr.Skip()
+	default:
+		return fmt.Errorf("cannot decode %v into a big integer", vr.Type())
+	}
+
+	return nil
+}
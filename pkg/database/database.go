@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"sync"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
 )
 
 var once sync.Once
@@ -20,7 +22,7 @@ func ConnectToMongoDB() error {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		mongoClient, err := mongo.NewClient(options.Client().ApplyURI(configs.MongoEndpoint))
+		mongoClient, err := mongo.NewClient(options.Client().ApplyURI(configs.MongoEndpoint).SetRegistry(newRegistry()))
 		if err != nil {
 			err = fmt.Errorf("Failed to create MongoDB client: %v", err)
 			return
@@ -38,9 +40,68 @@ func ConnectToMongoDB() error {
 	return err
 }
 
+// Enabled reports whether MongoDB is configured. Approvals, ownership
+// transfers, registered events and stored blocks are only kept in MongoDB.
+func Enabled() bool {
+	return configs.MongoEndpoint != ""
+}
+
 func GetDB() (*mongo.Database, error) {
 	if db == nil {
 		return nil, fmt.Errorf("database connection is not established")
 	}
 	return db, nil
 }
+
+var transactions struct {
+	once      sync.Once
+	supported bool
+}
+
+// SupportsTransactions reports whether the deployment is a replica set or a
+// sharded cluster, the only ones MongoDB runs transactions on.
+func SupportsTransactions(ctx context.Context) bool {
+	transactions.once.Do(func() {
+		db, err := GetDB()
+		if err != nil {
+			return
+		}
+
+		var result bson.M
+		err = db.Client().Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&result)
+		if err != nil {
+			logger.Logger.Warnf("[Database] Failed to detect transaction support: %v", err)
+			return
+		}
+
+		_, replicaSet := result["setName"]
+		transactions.supported = replicaSet || result["msg"] == "isdbgrid"
+	})
+
+	return transactions.supported
+}
+
+// WithTransaction runs fn in a transaction when the deployment supports them,
+// and directly otherwise. fn may run several times when a transaction is
+// retried, so it must not keep state across runs.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !SupportsTransactions(ctx) {
+		return fn(ctx)
+	}
+
+	db, err := GetDB()
+	if err != nil {
+		return err
+	}
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/junwei0117/logs-collector/pkg/logger"
+)
+
+// CreateUniqueIndex creates a unique index on keys. Duplicates stored before
+// the index existed are removed first, keeping the earliest document.
+func CreateUniqueIndex(collection string, keys bson.D) error {
+	db, err := GetDB()
+	if err != nil {
+		return err
+	}
+
+	model := mongo.IndexModel{Keys: keys, Options: options.Index().SetUnique(true)}
+
+	_, err = db.Collection(collection).Indexes().CreateOne(context.Background(), model)
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	removed, err := removeDuplicates(db, collection, keys)
+	if err != nil {
+		return err
+	}
+	logger.Logger.Warnf("[Database] Removed %v duplicate documents from %s", removed, collection)
+
+	_, err = db.Collection(collection).Indexes().CreateOne(context.Background(), model)
+	return err
+}
+
+func removeDuplicates(db *mongo.Database, collection string, keys bson.D) (int64, error) {
+	ctx := context.Background()
+
+	group := bson.M{}
+	for _, key := range keys {
+		group[key.Key] = "$" + key.Key
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{"_id": group, "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+
+	cursor, err := db.Collection(collection).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var removed int64
+	for cursor.Next(ctx) {
+		var duplicates struct {
+			IDs []interface{} `bson:"ids"`
+		}
+		if err := cursor.Decode(&duplicates); err != nil {
+			return removed, err
+		}
+
+		result, err := db.Collection(collection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicates.IDs[1:]}})
+		if err != nil {
+			return removed, err
+		}
+		removed += result.DeletedCount
+	}
+
+	return removed, cursor.Err()
+}
+
+// DuplicateIndexes returns the positions of the documents an unordered insert
+// rejected as duplicates, or err if anything else failed.
+func DuplicateIndexes(err error) (map[int]bool, error) {
+	if err == nil {
+		return nil, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return nil, err
+	}
+
+	duplicates := make(map[int]bool)
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !isDuplicateKeyCode(writeErr.Code) {
+			return nil, err
+		}
+		duplicates[writeErr.Index] = true
+	}
+
+	return duplicates, nil
+}
+
+func isDuplicateKeyCode(code int) bool {
+	return code == 11000 || code == 11001 || code == 12582
+}
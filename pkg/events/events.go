@@ -0,0 +1,264 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+)
+
+// Definition registers one event of an arbitrary contract ABI. Logs matching
+// it are decoded into a map of arguments and stored in Collection.
+type Definition struct {
+	Name       string   `json:"name"`
+	ABIFile    string   `json:"abiFile"`
+	Event      string   `json:"event"`
+	Contracts  []string `json:"contracts"`
+	Collection string   `json:"collection"`
+
+	abi       abi.ABI
+	event     abi.Event
+	contracts []common.Address
+}
+
+var definitions []*Definition
+
+// LoadDefinitions reads a JSON array of definitions. ABI file paths are
+// resolved relative to the configuration file.
+func LoadDefinitions(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var loaded []*Definition
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return err
+	}
+
+	// The built-in collections have their own documents and unique indexes.
+	reserved := make(map[string]bool)
+	for _, collection := range []string{
+		configs.MongoCollection,
+		configs.MongoCheckpointCollection,
+		configs.MongoApprovalCollection,
+		configs.MongoOwnershipCollection,
+		configs.MongoBlockCollection,
+		configs.MongoTokenCollection,
+		configs.MongoBalanceCollection,
+		configs.MongoSnapshotCollection,
+		configs.MongoDiscrepancyCollection,
+	} {
+		reserved[collection] = true
+	}
+
+	names := make(map[string]bool)
+	for _, definition := range loaded {
+		if definition.Name == "" || definition.Event == "" || definition.ABIFile == "" {
+			return fmt.Errorf("event definition requires name, event and abiFile: %+v", definition)
+		}
+		if names[definition.Name] {
+			return fmt.Errorf("duplicate event definition %q", definition.Name)
+		}
+		names[definition.Name] = true
+
+		abiPath := definition.ABIFile
+		if !filepath.IsAbs(abiPath) {
+			abiPath = filepath.Join(filepath.Dir(path), abiPath)
+		}
+
+		abiFile, err := os.Open(abiPath)
+		if err != nil {
+			return err
+		}
+		definition.abi, err = abi.JSON(abiFile)
+		abiFile.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse ABI of %q: %v", definition.Name, err)
+		}
+
+		event, ok := definition.abi.Events[definition.Event]
+		if !ok {
+			return fmt.Errorf("event %q not found in ABI of %q", definition.Event, definition.Name)
+		}
+		definition.event = event
+
+		for _, contract := range definition.Contracts {
+			if !common.IsHexAddress(contract) {
+				return fmt.Errorf("invalid contract address %q in %q", contract, definition.Name)
+			}
+			definition.contracts = append(definition.contracts, common.HexToAddress(contract))
+		}
+
+		if definition.Collection == "" {
+			definition.Collection = "events_" + definition.Name
+		}
+		if reserved[definition.Collection] {
+			return fmt.Errorf("collection %q of %q is reserved", definition.Collection, definition.Name)
+		}
+	}
+
+	definitions = loaded
+	return nil
+}
+
+func Definitions() []*Definition {
+	return definitions
+}
+
+func GetDefinition(name string) (*Definition, bool) {
+	for _, definition := range definitions {
+		if definition.Name == name {
+			return definition, true
+		}
+	}
+	return nil, false
+}
+
+func Topics() []common.Hash {
+	var topics []common.Hash
+	for _, definition := range definitions {
+		topics = append(topics, definition.event.ID)
+	}
+	return topics
+}
+
+// Addresses returns the contracts the definitions are restricted to, or nil
+// when any definition matches every contract.
+func Addresses() []common.Address {
+	var addresses []common.Address
+	for _, definition := range definitions {
+		if len(definition.contracts) == 0 {
+			return nil
+		}
+		addresses = append(addresses, definition.contracts...)
+	}
+	return addresses
+}
+
+func Collections() []string {
+	var collections []string
+	for _, definition := range definitions {
+		collections = append(collections, definition.Collection)
+	}
+	return collections
+}
+
+// Match returns the definitions vLog is an instance of. Events sharing a topic
+// can differ in which arguments are indexed, such as ERC-20 and ERC-721
+// Transfer, so logs with another number of topics are not matched.
+func Match(vLog types.Log) []*Definition {
+	if len(vLog.Topics) == 0 {
+		return nil
+	}
+
+	var matches []*Definition
+	for _, definition := range definitions {
+		if definition.event.ID != vLog.Topics[0] || !definition.matchesContract(vLog.Address) {
+			continue
+		}
+		if indexed := definition.indexedArguments(); len(vLog.Topics)-1 != len(indexed) {
+			logger.Logger.Debugf("[Events] Log %v of transaction %v has %v indexed arguments, %q expects %v", vLog.Index, vLog.TxHash.Hex(), len(vLog.Topics)-1, definition.Name, len(indexed))
+			continue
+		}
+		matches = append(matches, definition)
+	}
+	return matches
+}
+
+func (definition *Definition) matchesContract(address common.Address) bool {
+	if len(definition.contracts) == 0 {
+		return true
+	}
+	for _, contract := range definition.contracts {
+		if contract == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode unpacks both the indexed and non-indexed arguments of vLog. Values
+// are converted to strings where they would not round-trip through storage.
+func (definition *Definition) Decode(vLog types.Log) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+
+	if len(vLog.Data) > 0 {
+		if err := definition.abi.UnpackIntoMap(args, definition.Event, vLog.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	indexed := definition.indexedArguments()
+	if len(vLog.Topics)-1 != len(indexed) {
+		return nil, fmt.Errorf("expected %v indexed arguments for %q, got %v", len(indexed), definition.Name, len(vLog.Topics)-1)
+	}
+	if err := abi.ParseTopicsIntoMap(args, indexed, vLog.Topics[1:]); err != nil {
+		return nil, err
+	}
+
+	for name, value := range args {
+		args[name] = normalizeValue(value)
+	}
+
+	return args, nil
+}
+
+func (definition *Definition) indexedArguments() abi.Arguments {
+	var indexed abi.Arguments
+	for _, input := range definition.event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	return indexed
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *big.Int:
+		return v.String()
+	case common.Address:
+		return v.Hex()
+	case common.Hash:
+		return v.Hex()
+	case []byte:
+		return hexutil.Encode(v)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			bytes := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(bytes), rv)
+			return hexutil.Encode(bytes)
+		}
+		fallthrough
+	case reflect.Slice:
+		values := make([]interface{}, rv.Len())
+		for i := range values {
+			values[i] = normalizeValue(rv.Index(i).Interface())
+		}
+		return values
+	case reflect.Uint64, reflect.Uint:
+		// BSON has no unsigned 64-bit integer type.
+		return new(big.Int).SetUint64(rv.Uint()).String()
+	}
+
+	return value
+}
@@ -0,0 +1,131 @@
+package events
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const testABI = `[
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"}
+]`
+
+func loadTestDefinitions(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token.json"), []byte(testABI), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := `[{"name": "tokenTransfer", "abiFile": "token.json", "event": "Transfer"}]`
+	if err := os.WriteFile(filepath.Join(dir, "events.json"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadDefinitions(filepath.Join(dir, "events.json")); err != nil {
+		t.Fatalf("LoadDefinitions: %v", err)
+	}
+	t.Cleanup(func() { definitions = nil })
+}
+
+func TestMatchAndDecode(t *testing.T) {
+	loadTestDefinitions(t)
+
+	topic := crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	from := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	to := common.HexToAddress("0x0000000000000000000000000000000000000002")
+
+	tests := []struct {
+		name     string
+		vLog     types.Log
+		wantArgs map[string]interface{}
+		wantErr  bool
+	}{
+		{
+			name: "matching layout",
+			vLog: types.Log{
+				Topics: []common.Hash{topic, from.Hash(), to.Hash()},
+				Data:   common.LeftPadBytes(big.NewInt(42).Bytes(), 32),
+			},
+			wantArgs: map[string]interface{}{"from": from.Hex(), "to": to.Hex(), "value": "42"},
+		},
+		{
+			// ERC-721 indexes the token id, so the log is not this event.
+			name: "other indexed layout",
+			vLog: types.Log{Topics: []common.Hash{topic, from.Hash(), to.Hash(), common.BigToHash(big.NewInt(7))}},
+		},
+		{
+			name: "other topic",
+			vLog: types.Log{Topics: []common.Hash{crypto.Keccak256Hash([]byte("Approval(address,address,uint256)")), from.Hash(), to.Hash()}},
+		},
+		{
+			name: "malformed data",
+			vLog: types.Log{
+				Topics: []common.Hash{topic, from.Hash(), to.Hash()},
+				Data:   []byte{0x01},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches := Match(test.vLog)
+			if test.wantArgs == nil && !test.wantErr {
+				if len(matches) != 0 {
+					t.Fatalf("matched %v definitions, want none", len(matches))
+				}
+				return
+			}
+			if len(matches) != 1 {
+				t.Fatalf("matched %v definitions, want 1", len(matches))
+			}
+
+			args, err := matches[0].Decode(test.vLog)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("got args %v, want %v", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"big integer", big.NewInt(-5), "-5"},
+		{"address", common.HexToAddress("0x00000000000000000000000000000000000000aa"), "0x00000000000000000000000000000000000000AA"},
+		{"hash", common.HexToHash("0x01"), "0x0000000000000000000000000000000000000000000000000000000000000001"},
+		{"bytes", []byte{0xde, 0xad}, "0xdead"},
+		{"fixed bytes", [4]byte{0xca, 0xfe, 0xba, 0xbe}, "0xcafebabe"},
+		{"uint64", uint64(1) << 63, "9223372036854775808"},
+		{"uint8", uint8(18), uint8(18)},
+		{"bool", true, true},
+		{"string", "name", "name"},
+		{"array of integers", []*big.Int{big.NewInt(1), big.NewInt(2)}, []interface{}{"1", "2"}},
+		{"fixed array of addresses", [1]common.Address{{}}, []interface{}{"0x0000000000000000000000000000000000000000"}},
+	}
+
+	for _, test := range tests {
+		if got := normalizeValue(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("normalizeValue(%s) = %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
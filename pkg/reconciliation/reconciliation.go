@@ -0,0 +1,229 @@
+package reconciliation
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	loggerCommon "github.com/junwei0117/logs-collector/pkg/common"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+	"github.com/junwei0117/logs-collector/pkg/tokens"
+)
+
+// Number of transfers read per query while summing the supply of a token.
+const supplyPageSize = 1000
+
+// Report summarizes the last reconciliation of a chain.
+type Report struct {
+	ChainID       uint64 `json:"chainId"`
+	StartedAt     int64  `json:"startedAt"`
+	FinishedAt    int64  `json:"finishedAt,omitempty"`
+	BlockNumber   uint64 `json:"blockNumber"`
+	Checked       int    `json:"checked"`
+	Discrepancies int    `json:"discrepancies"`
+	Errors        int    `json:"errors"`
+	LastError     string `json:"lastError,omitempty"`
+}
+
+var reports = struct {
+	sync.Mutex
+	m map[uint64]Report
+}{
+	m: make(map[uint64]Report),
+}
+
+// Reports returns the last report of every chain reconciled so far.
+func Reports() []Report {
+	reports.Lock()
+	defer reports.Unlock()
+
+	result := []Report{}
+	for _, chain := range configs.Chains {
+		if report, ok := reports.m[chain.ChainID]; ok {
+			result = append(result, report)
+		}
+	}
+	return result
+}
+
+func setReport(report Report) {
+	reports.Lock()
+	reports.m[report.ChainID] = report
+	reports.Unlock()
+}
+
+// Start reconciles chain every ReconciliationInterval until the process exits.
+func Start(chain configs.Chain) {
+	if configs.ReconciliationInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(configs.ReconciliationInterval)
+
+			report, err := Run(context.Background(), chain)
+			if err != nil {
+				logger.Logger.Errorf("[Reconciliation] [%s] Failed to reconcile balances: %v", chain.Name, err)
+				continue
+			}
+
+			logger.Logger.Infof("[Reconciliation] [%s] Checked %v values at block %v, found %v discrepancies", chain.Name, report.Checked, report.BlockNumber, report.Discrepancies)
+		}
+	}()
+}
+
+// Run compares a random sample of ERC-20 balances, and the total supply of
+// their tokens, against what the contracts report at the last final block.
+// Values the contract cannot be asked about are counted as errors rather than
+// failing the run.
+func Run(ctx context.Context, chain configs.Chain) (*Report, error) {
+	report := Report{ChainID: chain.ChainID, StartedAt: time.Now().Unix()}
+
+	store, err := storage.GetStore()
+	if err != nil {
+		return nil, err
+	}
+
+	blockNumber, final, err := loggerCommon.FinalBlock(ctx, chain.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	if !final {
+		return nil, fmt.Errorf("no final block to reconcile yet")
+	}
+	report.BlockNumber = blockNumber
+
+	filter := storage.BalanceFilter{ChainID: &chain.ChainID, Standard: storage.TokenStandardERC20}
+	balances, err := store.SampleBalances(ctx, filter, configs.ReconciliationSampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	record := func(kind string, contract common.Address, account common.Address, indexed *big.Int, onChain *big.Int) {
+		report.Checked++
+		if indexed.Cmp(onChain) == 0 {
+			return
+		}
+
+		report.Discrepancies++
+		logger.Logger.Warnf("[Reconciliation] [%s] Indexed %s of %v for %v is %v, contract reports %v at block %v", chain.Name, kind, contract.Hex(), account.Hex(), indexed, onChain, blockNumber)
+
+		err := store.SaveDiscrepancy(ctx, &storage.Discrepancy{
+			ChainID:         chain.ChainID,
+			Kind:            kind,
+			ContractAddress: contract,
+			Account:         account,
+			BlockNumber:     blockNumber,
+			Indexed:         indexed,
+			OnChain:         onChain,
+			DetectedAt:      time.Now().Unix(),
+		})
+		if err != nil {
+			report.Errors++
+			report.LastError = err.Error()
+		}
+	}
+	fail := func(err error) {
+		report.Errors++
+		report.LastError = err.Error()
+	}
+
+	contracts := make(map[common.Address]bool)
+	for _, balance := range balances {
+		contracts[balance.ContractAddress] = true
+
+		indexed, err := balanceAt(ctx, chain, balance.ContractAddress, balance.Account, blockNumber)
+		if err != nil {
+			fail(err)
+			continue
+		}
+
+		onChain, err := tokens.BalanceOf(chain, balance.ContractAddress, balance.Account, blockNumber)
+		if err != nil {
+			fail(fmt.Errorf("balanceOf %v: %v", balance.ContractAddress.Hex(), err))
+			continue
+		}
+
+		record(storage.DiscrepancyBalance, balance.ContractAddress, balance.Account, indexed, onChain)
+	}
+
+	for contract := range contracts {
+		indexed, err := supplyAt(ctx, store, chain.ChainID, contract, blockNumber)
+		if err != nil {
+			fail(err)
+			continue
+		}
+
+		onChain, err := tokens.TotalSupply(chain, contract, blockNumber)
+		if err != nil {
+			fail(fmt.Errorf("totalSupply %v: %v", contract.Hex(), err))
+			continue
+		}
+
+		record(storage.DiscrepancySupply, contract, common.Address{}, indexed, onChain)
+	}
+
+	report.FinishedAt = time.Now().Unix()
+	setReport(report)
+
+	return &report, nil
+}
+
+// balanceAt returns the indexed balance account held of contract after
+// blockNumber.
+func balanceAt(ctx context.Context, chain configs.Chain, contract common.Address, account common.Address, blockNumber uint64) (*big.Int, error) {
+	balances, err := loggerCommon.BalancesAt(ctx, chain, account, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, balance := range balances {
+		if balance.ContractAddress == contract && balance.TokenID == nil {
+			return balance.Balance, nil
+		}
+	}
+	return new(big.Int), nil
+}
+
+// supplyAt sums what was minted minus what was burned of contract up to
+// blockNumber.
+func supplyAt(ctx context.Context, store storage.Store, chainID uint64, contract common.Address, blockNumber uint64) (*big.Int, error) {
+	zero := common.Address{}
+	filter := storage.TransferFilter{
+		ChainID:  &chainID,
+		Address:  &zero,
+		Contract: &contract,
+		ToBlock:  &blockNumber,
+	}
+
+	supply := new(big.Int)
+	for offset := int64(0); ; offset += supplyPageSize {
+		transfers, err := store.FindTransfers(ctx, filter, offset, supplyPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, transfer := range transfers {
+			if transfer.Value == nil {
+				continue
+			}
+			if transfer.From == zero {
+				supply.Add(supply, transfer.Value)
+			}
+			if transfer.To == zero {
+				supply.Sub(supply, transfer.Value)
+			}
+		}
+
+		if len(transfers) < supplyPageSize {
+			return supply, nil
+		}
+	}
+}
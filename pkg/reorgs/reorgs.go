@@ -0,0 +1,144 @@
+package reorgs
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/junwei0117/logs-collector/pkg/collectors"
+	loggerCommon "github.com/junwei0117/logs-collector/pkg/common"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+)
+
+// recentBlocks maps a chain ID to the block hashes seen on that chain.
+var recentBlocks = struct {
+	sync.Mutex
+	m map[uint64]map[uint64]common.Hash
+}{
+	m: make(map[uint64]map[uint64]common.Hash),
+}
+
+func setBlockHash(chainID uint64, blockNumber uint64, blockHash common.Hash) (common.Hash, bool) {
+	blocks, ok := recentBlocks.m[chainID]
+	if !ok {
+		blocks = make(map[uint64]common.Hash)
+		recentBlocks.m[chainID] = blocks
+	}
+
+	previousHash, ok := blocks[blockNumber]
+	blocks[blockNumber] = blockHash
+
+	return previousHash, ok
+}
+
+// trackBlock records the hash seen for blockNumber and returns the hash
+// recorded earlier for the same height, if any.
+func trackBlock(chainID uint64, blockNumber uint64, blockHash common.Hash) (common.Hash, bool) {
+	recentBlocks.Lock()
+	defer recentBlocks.Unlock()
+
+	previousHash, ok := setBlockHash(chainID, blockNumber, blockHash)
+
+	for number := range recentBlocks.m[chainID] {
+		if number+configs.ReorgDepth < blockNumber {
+			delete(recentBlocks.m[chainID], number)
+		}
+	}
+
+	return previousHash, ok
+}
+
+func trackedBlocksSince(chainID uint64, blockNumber uint64) []uint64 {
+	recentBlocks.Lock()
+	defer recentBlocks.Unlock()
+
+	var numbers []uint64
+	for number := range recentBlocks.m[chainID] {
+		if number >= blockNumber {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	return numbers
+}
+
+// HandleLog must be called for every live log before it is stored, so events
+// from orphaned blocks are rolled back before their replacements are inserted.
+func HandleLog(chain configs.Chain, vLog types.Log) (bool, error) {
+	if vLog.Removed {
+		logger.Logger.Warnf("[Reorg] [%s] Removing event from orphaned block %v (%v)", chain.Name, vLog.BlockNumber, vLog.BlockHash.Hex())
+		return true, loggerCommon.RemoveLogs(chain.ChainID, vLog)
+	}
+
+	previousHash, ok := trackBlock(chain.ChainID, vLog.BlockNumber, vLog.BlockHash)
+	if !ok {
+		// Blocks seen before a restart are only known from the blocks collection.
+		block, stored, err := loggerCommon.LookupBlock(chain.ChainID, vLog.BlockNumber)
+		if err != nil {
+			return false, err
+		}
+		if stored {
+			previousHash, ok = block.Hash, true
+		}
+	}
+
+	if !ok || previousHash == vLog.BlockHash {
+		return false, nil
+	}
+
+	logger.Logger.Warnf("[Reorg] [%s] Detected chain reorganization at block %v", chain.Name, vLog.BlockNumber)
+
+	return false, Rollback(chain, vLog.BlockNumber)
+}
+
+// Rollback deletes events of every tracked block since blockNumber that are
+// no longer part of the canonical chain and re-ingests the canonical logs.
+func Rollback(chain configs.Chain, blockNumber uint64) error {
+	for _, number := range trackedBlocksSince(chain.ChainID, blockNumber) {
+		canonicalHash, err := collectors.GetBlockHash(chain, number)
+		if err != nil {
+			return err
+		}
+
+		removed, err := loggerCommon.RemoveOrphanedLogs(chain.ChainID, number, canonicalHash)
+		if err != nil {
+			return err
+		}
+
+		recentBlocks.Lock()
+		setBlockHash(chain.ChainID, number, canonicalHash)
+		recentBlocks.Unlock()
+
+		if removed == 0 && number != blockNumber {
+			continue
+		}
+
+		logger.Logger.Warnf("[Reorg] [%s] Removed %v orphaned events at block %v", chain.Name, removed, number)
+
+		if err := loggerCommon.ForgetBlock(chain.ChainID, number); err != nil {
+			return err
+		}
+
+		logs, err := collectors.GetTransferLogs(chain, int64(number), int64(number))
+		if err != nil {
+			return err
+		}
+
+		var canonicalLogs []types.Log
+		for _, canonicalLog := range logs {
+			if canonicalLog.BlockHash == canonicalHash {
+				canonicalLogs = append(canonicalLogs, canonicalLog)
+			}
+		}
+
+		if _, err := loggerCommon.HandleLogBatch(chain, canonicalLogs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
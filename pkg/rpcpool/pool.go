@@ -0,0 +1,359 @@
+package rpcpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+)
+
+const (
+	// Weight of the latest sample in the moving averages.
+	smoothing = 0.2
+	// Endpoints are skipped for this long after a failure, doubling with every
+	// consecutive failure up to maxCooldown.
+	minCooldown = time.Second
+	maxCooldown = time.Minute * 5
+)
+
+// Client is the subset of ethclient.Client the indexer relies on. Packages
+// only see this interface, so a fake chain can be injected through SetDialer.
+type Client interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error)
+	SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error)
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+	CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	Close()
+}
+
+type Dialer func(ctx context.Context, url string) (Client, error)
+
+// ethClient exposes raw and batch calls of the underlying RPC client next to
+// the typed ethclient methods.
+type ethClient struct {
+	*ethclient.Client
+	rpcClient *rpc.Client
+}
+
+func (client *ethClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return client.rpcClient.CallContext(ctx, result, method, args...)
+}
+
+func (client *ethClient) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	return client.rpcClient.BatchCallContext(ctx, b)
+}
+
+func dialEthclient(ctx context.Context, url string) (Client, error) {
+	rpcClient, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &ethClient{Client: ethclient.NewClient(rpcClient), rpcClient: rpcClient}, nil
+}
+
+var dialer = struct {
+	sync.Mutex
+	dial Dialer
+}{
+	dial: dialEthclient,
+}
+
+// SetDialer replaces how endpoints connect. Connections already established
+// are kept until they fail.
+func SetDialer(dial Dialer) {
+	dialer.Lock()
+	defer dialer.Unlock()
+
+	dialer.dial = dial
+}
+
+func dial(ctx context.Context, url string) (Client, error) {
+	dialer.Lock()
+	connect := dialer.dial
+	dialer.Unlock()
+
+	return connect(ctx, url)
+}
+
+// connection is shared by the concurrent requests of an endpoint. It is only
+// closed once it was dropped and its last user released it.
+type connection struct {
+	client  Client
+	users   int
+	dropped bool
+}
+
+type Endpoint struct {
+	mu sync.Mutex
+
+	url  string
+	conn *connection
+
+	latency             time.Duration
+	errorRate           float64
+	consecutiveFailures int
+	cooldownUntil       time.Time
+	requests            uint64
+	failures            uint64
+}
+
+type EndpointStats struct {
+	Host                string  `json:"host"`
+	LatencyMs           float64 `json:"latencyMs"`
+	ErrorRate           float64 `json:"errorRate"`
+	ConsecutiveFailures int     `json:"consecutiveFailures"`
+	Requests            uint64  `json:"requests"`
+	Failures            uint64  `json:"failures"`
+	Healthy             bool    `json:"healthy"`
+}
+
+type Pool struct {
+	name      string
+	endpoints []*Endpoint
+}
+
+func New(name string, urls []string) *Pool {
+	pool := &Pool{name: name}
+	for _, url := range urls {
+		pool.endpoints = append(pool.endpoints, &Endpoint{url: url})
+	}
+	return pool
+}
+
+var pools = struct {
+	sync.Mutex
+	m map[string]*Pool
+}{
+	m: make(map[string]*Pool),
+}
+
+func getPool(name string, urls []string) *Pool {
+	pools.Lock()
+	defer pools.Unlock()
+
+	pool, ok := pools.m[name]
+	if !ok {
+		pool = New(name, urls)
+		pools.m[name] = pool
+	}
+	return pool
+}
+
+// HTTP returns the process-wide pool of JSON-RPC endpoints of chain.
+func HTTP(chain configs.Chain) *Pool {
+	return getPool(chain.Name+"/http", chain.HTTPEndpoints())
+}
+
+// Websocket returns the process-wide pool of websocket endpoints of chain.
+func Websocket(chain configs.Chain) *Pool {
+	return getPool(chain.Name+"/ws", chain.WebsocketEndpoints())
+}
+
+func (pool *Pool) Stats() []EndpointStats {
+	var stats []EndpointStats
+	for _, endpoint := range pool.endpoints {
+		stats = append(stats, endpoint.stats())
+	}
+	return stats
+}
+
+// Do runs fn against the healthiest endpoint and fails over to the next one
+// when the endpoint itself is at fault. Errors returned by the node for the
+// request, such as a JSON-RPC error, are returned as they are.
+func (pool *Pool) Do(ctx context.Context, fn func(client Client) error) error {
+	if len(pool.endpoints) == 0 {
+		return fmt.Errorf("no RPC endpoints configured for %s", pool.name)
+	}
+
+	var lastErr error
+	for _, endpoint := range pool.ranked() {
+		conn, err := endpoint.acquire(ctx)
+		if err != nil {
+			endpoint.Report(0, err)
+			lastErr = err
+			continue
+		}
+
+		start := time.Now()
+		err = fn(conn.client)
+		endpoint.release(conn, err)
+		endpoint.Report(time.Since(start), err)
+
+		if err == nil || !IsEndpointError(err) || ctx.Err() != nil {
+			return err
+		}
+
+		logger.Logger.Warnf("[RPC] Endpoint %s of %s failed: %v. failing over...", endpoint.host(), pool.name, err)
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+// Dial connects a dedicated client to the healthiest endpoint for long-lived
+// use such as subscriptions. Callers close it and report failures through the
+// endpoint.
+func (pool *Pool) Dial(ctx context.Context) (*Endpoint, Client, error) {
+	if len(pool.endpoints) == 0 {
+		return nil, nil, fmt.Errorf("no RPC endpoints configured for %s", pool.name)
+	}
+
+	var lastErr error
+	for _, endpoint := range pool.ranked() {
+		client, err := dial(ctx, endpoint.url)
+		if err == nil {
+			return endpoint, client, nil
+		}
+		endpoint.Report(0, err)
+		lastErr = err
+	}
+
+	return nil, nil, lastErr
+}
+
+// IsEndpointError tells connectivity and availability problems apart from
+// errors the node returned for the request itself. Cancelled and expired
+// contexts are up to the caller.
+func IsEndpointError(err error) bool {
+	if err == nil || errors.Is(err, ethereum.NotFound) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
+
+func (pool *Pool) ranked() []*Endpoint {
+	endpoints := append([]*Endpoint{}, pool.endpoints...)
+	now := time.Now()
+
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		iCooling, jCooling := endpoints[i].coolingDown(now), endpoints[j].coolingDown(now)
+		if iCooling != jCooling {
+			return !iCooling
+		}
+		return endpoints[i].score() < endpoints[j].score()
+	})
+
+	return endpoints
+}
+
+func (endpoint *Endpoint) acquire(ctx context.Context) (*connection, error) {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+
+	if endpoint.conn == nil {
+		client, err := dial(ctx, endpoint.url)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.conn = &connection{client: client}
+	}
+	endpoint.conn.users++
+
+	return endpoint.conn, nil
+}
+
+// release drops the connection after an endpoint error so the next request
+// dials again. Requests still running on it keep it open until they finish.
+func (endpoint *Endpoint) release(conn *connection, err error) {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+
+	conn.users--
+	if IsEndpointError(err) && endpoint.conn == conn {
+		endpoint.conn = nil
+		conn.dropped = true
+	}
+	if conn.dropped && conn.users == 0 {
+		conn.client.Close()
+	}
+}
+
+// Report records the outcome of a request.
+func (endpoint *Endpoint) Report(latency time.Duration, err error) {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+
+	endpoint.requests++
+
+	if !IsEndpointError(err) {
+		if endpoint.latency == 0 {
+			endpoint.latency = latency
+		} else {
+			endpoint.latency = time.Duration((1-smoothing)*float64(endpoint.latency) + smoothing*float64(latency))
+		}
+		endpoint.errorRate = (1 - smoothing) * endpoint.errorRate
+		endpoint.consecutiveFailures = 0
+		return
+	}
+
+	endpoint.failures++
+	endpoint.errorRate = (1-smoothing)*endpoint.errorRate + smoothing
+	endpoint.consecutiveFailures++
+
+	cooldown := minCooldown << (endpoint.consecutiveFailures - 1)
+	if cooldown > maxCooldown || cooldown <= 0 {
+		cooldown = maxCooldown
+	}
+	endpoint.cooldownUntil = time.Now().Add(cooldown)
+}
+
+func (endpoint *Endpoint) coolingDown(now time.Time) bool {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+
+	return now.Before(endpoint.cooldownUntil)
+}
+
+// score favours fast endpoints and penalizes recent errors heavily. Lower is
+// better.
+func (endpoint *Endpoint) score() float64 {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+
+	return float64(endpoint.latency) * (1 + endpoint.errorRate*10)
+}
+
+func (endpoint *Endpoint) host() string {
+	parsed, err := url.Parse(endpoint.url)
+	if err != nil {
+		return "invalid"
+	}
+	return parsed.Host
+}
+
+// stats leaves out the full URL, as provider URLs often embed API keys.
+func (endpoint *Endpoint) stats() EndpointStats {
+	healthy := !endpoint.coolingDown(time.Now())
+
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+
+	return EndpointStats{
+		Host:                endpoint.host(),
+		LatencyMs:           float64(endpoint.latency) / float64(time.Millisecond),
+		ErrorRate:           endpoint.errorRate,
+		ConsecutiveFailures: endpoint.consecutiveFailures,
+		Requests:            endpoint.requests,
+		Failures:            endpoint.failures,
+		Healthy:             healthy,
+	}
+}
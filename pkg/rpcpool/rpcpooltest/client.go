@@ -0,0 +1,171 @@
+// Package rpcpooltest provides an in-memory chain to inject through
+// rpcpool.SetDialer in tests.
+package rpcpooltest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
+)
+
+// Client serves the headers and logs it was given. FilterLogs rejects queries
+// spanning more than MaxBlockRange blocks with a JSON-RPC error the way
+// providers do, and fails with Err when it is set.
+type Client struct {
+	mu sync.Mutex
+
+	ChainIDValue  uint64
+	Headers       map[uint64]*types.Header
+	Logs          []types.Log
+	MaxBlockRange uint64
+	Err           error
+
+	Queries       []ethereum.FilterQuery
+	HeaderLookups int
+}
+
+// Error is a JSON-RPC error returned by the node, which the pool does not
+// fail over on.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (err *Error) Error() string  { return err.Message }
+func (err *Error) ErrorCode() int { return err.Code }
+
+// Dialer connects every endpoint to client.
+func (client *Client) Dialer() rpcpool.Dialer {
+	return func(ctx context.Context, url string) (rpcpool.Client, error) {
+		return client, nil
+	}
+}
+
+func (client *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).SetUint64(client.ChainIDValue), nil
+}
+
+func (client *Client) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	header, err := client.HeaderByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewBlockWithHeader(header), nil
+}
+
+func (client *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.HeaderLookups++
+
+	if number == nil {
+		var latest *types.Header
+		for _, header := range client.Headers {
+			if latest == nil || header.Number.Cmp(latest.Number) > 0 {
+				latest = header
+			}
+		}
+		if latest == nil {
+			return nil, ethereum.NotFound
+		}
+		return latest, nil
+	}
+
+	header, ok := client.Headers[number.Uint64()]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return header, nil
+}
+
+func (client *Client) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.Queries = append(client.Queries, query)
+
+	if client.Err != nil {
+		return nil, client.Err
+	}
+
+	from, to := query.FromBlock.Uint64(), query.ToBlock.Uint64()
+	if client.MaxBlockRange > 0 && to-from+1 > client.MaxBlockRange {
+		return nil, &Error{Code: -32005, Message: fmt.Sprintf("block range is too large, max is %v", client.MaxBlockRange)}
+	}
+
+	var logs []types.Log
+	for _, vLog := range client.Logs {
+		if vLog.BlockNumber >= from && vLog.BlockNumber <= to {
+			logs = append(logs, vLog)
+		}
+	}
+	return logs, nil
+}
+
+func (client *Client) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return nil, errors.New("subscriptions are not supported")
+}
+
+// CallContext serves eth_getBlockByNumber from Headers, reporting the hash
+// computed by types.Header.
+func (client *Client) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if method != "eth_getBlockByNumber" || len(args) == 0 {
+		return fmt.Errorf("method %v is not supported", method)
+	}
+
+	number, ok := args[0].(string)
+	if !ok {
+		return fmt.Errorf("invalid block number %v", args[0])
+	}
+	blockNumber, err := hexutil.DecodeUint64(number)
+	if err != nil {
+		return err
+	}
+
+	var block interface{}
+	header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(blockNumber))
+	if err == nil {
+		block = map[string]interface{}{
+			"number":    hexutil.Uint64(header.Number.Uint64()),
+			"hash":      header.Hash(),
+			"timestamp": hexutil.Uint64(header.Time),
+		}
+	} else if !errors.Is(err, ethereum.NotFound) {
+		return err
+	}
+
+	encoded, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, result)
+}
+
+func (client *Client) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	for i := range b {
+		b[i].Error = client.CallContext(ctx, b[i].Result, b[i].Method, b[i].Args...)
+	}
+	return nil
+}
+
+func (client *Client) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return nil, nil
+}
+
+func (client *Client) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, errors.New("contract calls are not supported")
+}
+
+func (client *Client) Close() {}
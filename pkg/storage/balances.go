@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Balance is what an account holds of a token. ERC-721 and ERC-1155 balances
+// are kept per token ID. Balances may be negative when the history of the
+// token was not indexed from its deployment.
+type Balance struct {
+	ChainID         uint64         `json:"chainId"`
+	ContractAddress common.Address `json:"contractAddress"`
+	Standard        string         `json:"standard"`
+	TokenID         *big.Int       `json:"tokenId,omitempty"`
+	Account         common.Address `json:"account"`
+	Balance         *big.Int       `json:"balance"`
+}
+
+// BalanceFilter selects balances. Nil fields match every balance.
+type BalanceFilter struct {
+	ChainID  *uint64
+	Account  *common.Address
+	Contract *common.Address
+	TokenID  *big.Int
+	Standard string
+	// Positive leaves out the negative balances of tokens whose history is
+	// incomplete.
+	Positive bool
+	// Ascending lists the smallest balances first instead of the largest.
+	Ascending bool
+}
+
+// BalanceKey orders balances like ValueKey orders values. Negative balances
+// sort below all others: "-" sorts below the digits, and 2^256 is added to
+// them so that the keys of larger negative balances sort higher.
+func BalanceKey(balance *big.Int) string {
+	if balance != nil && balance.Sign() < 0 {
+		return "-" + ValueKey(new(big.Int).Add(negativeBalanceOffset, balance))
+	}
+	return ValueKey(balance)
+}
+
+var negativeBalanceOffset = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// firstBlocks returns the lowest block of the transfers on each chain.
+// Snapshots taken at or after it no longer add up once they are inserted or
+// removed.
+func firstBlocks(transfers []*TransferLog) map[uint64]uint64 {
+	blocks := make(map[uint64]uint64)
+	for _, transfer := range transfers {
+		if first, ok := blocks[transfer.ChainID]; !ok || transfer.BlockNumber < first {
+			blocks[transfer.ChainID] = transfer.BlockNumber
+		}
+	}
+	return blocks
+}
+
+type balanceKey struct {
+	chainID  uint64
+	contract common.Address
+	tokenID  string
+	account  common.Address
+}
+
+type balanceChange struct {
+	key      balanceKey
+	standard string
+	delta    *big.Int
+}
+
+func tokenIDString(transfer *TransferLog) string {
+	if transfer.Standard == TokenStandardERC721 || transfer.Standard == TokenStandardERC1155 {
+		return bigIntString(transfer.TokenID)
+	}
+	return ""
+}
+
+// addBalanceChanges sums into changes what the transfers move in and out of
+// every account, negated when the transfers are being removed. The zero
+// address, which stands for mints and burns, has no balance.
+func addBalanceChanges(changes map[balanceKey]*balanceChange, transfers []*TransferLog, removed bool) {
+	add := func(transfer *TransferLog, account common.Address, delta *big.Int) {
+		if account == (common.Address{}) || delta.Sign() == 0 {
+			return
+		}
+
+		key := balanceKey{
+			chainID:  transfer.ChainID,
+			contract: transfer.ContractAddress,
+			tokenID:  tokenIDString(transfer),
+			account:  account,
+		}
+
+		change, ok := changes[key]
+		if !ok {
+			standard := transfer.Standard
+			if standard == "" {
+				standard = TokenStandardERC20
+			}
+			change = &balanceChange{key: key, standard: standard, delta: new(big.Int)}
+			changes[key] = change
+		}
+		change.delta.Add(change.delta, delta)
+	}
+
+	for _, transfer := range transfers {
+		value := new(big.Int)
+		if transfer.Value != nil {
+			value.Set(transfer.Value)
+		}
+		if removed {
+			value.Neg(value)
+		}
+
+		add(transfer, transfer.From, new(big.Int).Neg(value))
+		add(transfer, transfer.To, value)
+	}
+}
+
+// sortBalanceChanges drops the changes that cancel out and sorts the others,
+// so that concurrent writers lock balances in the same order.
+func sortBalanceChanges(changes map[balanceKey]*balanceChange) []*balanceChange {
+	sorted := make([]*balanceChange, 0, len(changes))
+	for _, change := range changes {
+		if change.delta.Sign() != 0 {
+			sorted = append(sorted, change)
+		}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].key, sorted[j].key
+		if a.chainID != b.chainID {
+			return a.chainID < b.chainID
+		}
+		if c := bytes.Compare(a.contract[:], b.contract[:]); c != 0 {
+			return c < 0
+		}
+		if c := strings.Compare(a.tokenID, b.tokenID); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(a.account[:], b.account[:]) < 0
+	})
+
+	return sorted
+}
+
+func balanceChanges(transfers []*TransferLog, removed bool) []*balanceChange {
+	changes := make(map[balanceKey]*balanceChange)
+	addBalanceChanges(changes, transfers, removed)
+	return sortBalanceChanges(changes)
+}
+
+// parseTokenID reverses tokenIDString.
+func parseTokenID(tokenID string) *big.Int {
+	if tokenID == "" {
+		return nil
+	}
+	value, _ := new(big.Int).SetString(tokenID, 10)
+	return value
+}
+
+// BalanceSnapshot holds the balances of an account after a block.
+type BalanceSnapshot struct {
+	ChainID     uint64         `json:"chainId"`
+	Account     common.Address `json:"account"`
+	BlockNumber uint64         `json:"blockNumber"`
+	Balances    []*Balance     `json:"balances"`
+}
+
+// ApplyTransfers returns the balances of account once the transfers are added
+// to balances, ordered from the largest.
+func ApplyTransfers(balances []*Balance, account common.Address, transfers []*TransferLog) []*Balance {
+	totals := make(map[balanceKey]*Balance)
+	for _, balance := range balances {
+		key := balanceKey{
+			chainID:  balance.ChainID,
+			contract: balance.ContractAddress,
+			tokenID:  bigIntTokenID(balance.TokenID),
+			account:  account,
+		}
+		totals[key] = &Balance{
+			ChainID:         balance.ChainID,
+			ContractAddress: balance.ContractAddress,
+			Standard:        balance.Standard,
+			TokenID:         balance.TokenID,
+			Account:         account,
+			Balance:         new(big.Int).Set(balance.Balance),
+		}
+	}
+
+	var involved []*TransferLog
+	for _, transfer := range transfers {
+		if transfer.From == account || transfer.To == account {
+			involved = append(involved, transfer)
+		}
+	}
+
+	for _, change := range balanceChanges(involved, false) {
+		if change.key.account != account {
+			continue
+		}
+
+		total, ok := totals[change.key]
+		if !ok {
+			total = &Balance{
+				ChainID:         change.key.chainID,
+				ContractAddress: change.key.contract,
+				Standard:        change.standard,
+				TokenID:         parseTokenID(change.key.tokenID),
+				Account:         account,
+				Balance:         new(big.Int),
+			}
+			totals[change.key] = total
+		}
+		total.Balance.Add(total.Balance, change.delta)
+	}
+
+	result := []*Balance{}
+	for _, total := range totals {
+		if total.Balance.Sign() != 0 {
+			result = append(result, total)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if c := strings.Compare(BalanceKey(a.Balance), BalanceKey(b.Balance)); c != 0 {
+			return c > 0
+		}
+		if c := bytes.Compare(a.ContractAddress[:], b.ContractAddress[:]); c != 0 {
+			return c < 0
+		}
+		return strings.Compare(bigIntTokenID(a.TokenID), bigIntTokenID(b.TokenID)) < 0
+	})
+
+	return result
+}
+
+func bigIntTokenID(tokenID *big.Int) string {
+	if tokenID == nil {
+		return ""
+	}
+	return tokenID.String()
+}
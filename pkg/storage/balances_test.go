@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestBalanceKeyOrder(t *testing.T) {
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+	// Keys are compared bytewise, in the order of the balances.
+	balances := []*big.Int{
+		new(big.Int).Neg(maxUint256),
+		new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 255)),
+		big.NewInt(-1000),
+		big.NewInt(-5),
+		big.NewInt(-1),
+		big.NewInt(0),
+		big.NewInt(1),
+		big.NewInt(5),
+		big.NewInt(1000),
+		new(big.Int).Lsh(big.NewInt(1), 255),
+		maxUint256,
+	}
+
+	for i := 1; i < len(balances); i++ {
+		previous, current := BalanceKey(balances[i-1]), BalanceKey(balances[i])
+		if previous >= current {
+			t.Errorf("BalanceKey(%v) = %q does not sort below BalanceKey(%v) = %q", balances[i-1], previous, balances[i], current)
+		}
+	}
+
+	// The "positive" filter selects keys from BalanceKey(1) up.
+	for _, balance := range balances {
+		if got, want := BalanceKey(balance) >= BalanceKey(big.NewInt(1)), balance.Sign() > 0; got != want {
+			t.Errorf("BalanceKey(%v) passes the positive range %v, want %v", balance, got, want)
+		}
+	}
+}
+
+func TestValueKey(t *testing.T) {
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+	tests := []struct {
+		value *big.Int
+		want  string
+	}{
+		{nil, "000000000000000000000000000000000000000000000000000000000000000000000000000000"},
+		{big.NewInt(0), "000000000000000000000000000000000000000000000000000000000000000000000000000000"},
+		{big.NewInt(42), "000000000000000000000000000000000000000000000000000000000000000000000000000042"},
+		{maxUint256, "115792089237316195423570985008687907853269984665640564039457584007913129639935"},
+	}
+
+	for _, test := range tests {
+		if got := ValueKey(test.value); got != test.want {
+			t.Errorf("ValueKey(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+
+	values := []*big.Int{big.NewInt(0), big.NewInt(9), big.NewInt(10), big.NewInt(99), big.NewInt(100), maxUint256}
+	for i := 1; i < len(values); i++ {
+		if ValueKey(values[i-1]) >= ValueKey(values[i]) {
+			t.Errorf("ValueKey(%v) does not sort below ValueKey(%v)", values[i-1], values[i])
+		}
+	}
+}
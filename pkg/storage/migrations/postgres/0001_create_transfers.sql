@@ -0,0 +1,25 @@
+CREATE TABLE transfers (
+	chain_id BIGINT NOT NULL,
+	from_address TEXT NOT NULL,
+	to_address TEXT NOT NULL,
+	value NUMERIC(78, 0) NOT NULL,
+	contract_address TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	block_hash TEXT NOT NULL,
+	tx_hash TEXT NOT NULL,
+	tx_index INTEGER NOT NULL,
+	log_index INTEGER NOT NULL,
+	block_timestamp BIGINT NOT NULL,
+	status TEXT NOT NULL,
+	standard TEXT NOT NULL,
+	token_id NUMERIC(78, 0),
+	operator TEXT NOT NULL,
+	batch_index INTEGER NOT NULL,
+	PRIMARY KEY (chain_id, tx_hash, log_index, batch_index)
+);
+
+CREATE INDEX transfers_block_number_idx ON transfers (chain_id, block_number);
+CREATE INDEX transfers_block_timestamp_idx ON transfers (block_timestamp);
+CREATE INDEX transfers_from_address_idx ON transfers (from_address);
+CREATE INDEX transfers_to_address_idx ON transfers (to_address);
+CREATE INDEX transfers_contract_address_idx ON transfers (contract_address);
@@ -0,0 +1,7 @@
+CREATE TABLE checkpoints (
+	chain_id BIGINT NOT NULL,
+	collection TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL,
+	PRIMARY KEY (chain_id, collection)
+);
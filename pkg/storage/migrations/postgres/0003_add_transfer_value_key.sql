@@ -0,0 +1,5 @@
+ALTER TABLE transfers ADD COLUMN value_key TEXT NOT NULL DEFAULT '';
+
+UPDATE transfers SET value_key = lpad(value::text, 78, '0');
+
+CREATE INDEX transfers_value_key_idx ON transfers (value_key);
@@ -0,0 +1,13 @@
+CREATE TABLE balances (
+	chain_id BIGINT NOT NULL,
+	contract_address TEXT NOT NULL,
+	standard TEXT NOT NULL,
+	token_id TEXT NOT NULL,
+	account TEXT NOT NULL,
+	balance NUMERIC(78, 0) NOT NULL,
+	balance_key TEXT NOT NULL,
+	PRIMARY KEY (chain_id, contract_address, token_id, account)
+);
+
+CREATE INDEX balances_account_idx ON balances (account, balance_key);
+CREATE INDEX balances_contract_address_idx ON balances (contract_address, balance_key);
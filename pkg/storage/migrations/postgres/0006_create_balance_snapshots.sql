@@ -0,0 +1,7 @@
+CREATE TABLE balance_snapshots (
+	chain_id BIGINT NOT NULL,
+	account TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	balances TEXT NOT NULL,
+	PRIMARY KEY (chain_id, account, block_number)
+);
@@ -0,0 +1,3 @@
+-- Negative balance keys used to hold the absolute value, which sorted -5 above
+-- -1. They now hold 2^256 plus the balance.
+UPDATE balances SET balance_key = '-' || lpad((power(2::numeric, 256) + balance)::numeric(78, 0)::text, 78, '0') WHERE balance < 0;
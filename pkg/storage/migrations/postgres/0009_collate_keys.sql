@@ -0,0 +1,5 @@
+-- Keys are compared bytewise. Locale collations such as en_US.UTF-8 ignore the
+-- leading '-' of negative balance keys, which then sort as the largest.
+-- SQLite already compares TEXT bytewise.
+ALTER TABLE transfers ALTER COLUMN value_key TYPE TEXT COLLATE "C";
+ALTER TABLE balances ALTER COLUMN balance_key TYPE TEXT COLLATE "C";
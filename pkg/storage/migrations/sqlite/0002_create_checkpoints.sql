@@ -0,0 +1,7 @@
+CREATE TABLE checkpoints (
+	chain_id INTEGER NOT NULL,
+	collection TEXT NOT NULL,
+	block_number INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (chain_id, collection)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE transfers ADD COLUMN value_key TEXT NOT NULL DEFAULT '';
+
+UPDATE transfers SET value_key = substr('000000000000000000000000000000000000000000000000000000000000000000000000000000' || value, -78);
+
+CREATE INDEX transfers_value_key_idx ON transfers (value_key);
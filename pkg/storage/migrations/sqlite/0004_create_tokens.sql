@@ -0,0 +1,9 @@
+CREATE TABLE tokens (
+	chain_id INTEGER NOT NULL,
+	address TEXT NOT NULL,
+	name TEXT NOT NULL,
+	symbol TEXT NOT NULL,
+	decimals INTEGER,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (chain_id, address)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE balance_snapshots (
+	chain_id INTEGER NOT NULL,
+	account TEXT NOT NULL,
+	block_number INTEGER NOT NULL,
+	balances TEXT NOT NULL,
+	PRIMARY KEY (chain_id, account, block_number)
+);
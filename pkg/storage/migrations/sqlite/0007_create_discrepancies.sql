@@ -0,0 +1,13 @@
+CREATE TABLE discrepancies (
+	chain_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	contract_address TEXT NOT NULL,
+	account TEXT NOT NULL,
+	block_number INTEGER NOT NULL,
+	indexed_value TEXT NOT NULL,
+	on_chain_value TEXT NOT NULL,
+	detected_at INTEGER NOT NULL,
+	PRIMARY KEY (chain_id, kind, contract_address, account, block_number)
+);
+
+CREATE INDEX discrepancies_detected_at_idx ON discrepancies (detected_at);
@@ -0,0 +1,4 @@
+-- Negative balance keys used to hold the absolute value, which sorted -5 above
+-- -1. SQLite cannot compute the new keys, so the balances are rebuilt from the
+-- transfers once the table is empty.
+DELETE FROM balances;
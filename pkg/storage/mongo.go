@@ -0,0 +1,1100 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+)
+
+type mongoStore struct{}
+
+// mongoTransfer is the stored form of a transfer, with the value key used to
+// filter and sort by value.
+type mongoTransfer struct {
+	TransferLog  `bson:",inline"`
+	ValueKey     string
+	BalanceState string `bson:",omitempty"`
+}
+
+// Transfers are stored before their balance changes are applied, and keep a
+// balance state until they are. A write interrupted in between leaves them
+// pending, to be applied when they are stored again or at startup. Applied
+// transfers, like the ones stored before the state was tracked, have none.
+const (
+	balancePending  = "pending"
+	balanceApplying = "applying"
+)
+
+type storedTransfer struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	TransferLog `bson:",inline"`
+}
+
+func (s *mongoStore) transfers() (*mongo.Collection, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	return db.Collection(configs.MongoCollection), nil
+}
+
+// mongoBalance is the stored form of a balance. The token ID is a string,
+// empty for ERC-20 tokens, so that it is part of the unique key.
+type mongoBalance struct {
+	ChainID         uint64
+	ContractAddress common.Address
+	Standard        string
+	TokenID         string
+	Account         common.Address
+	Balance         *big.Int
+	BalanceKey      string
+}
+
+func (s *mongoStore) balances() (*mongo.Collection, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	return db.Collection(configs.MongoBalanceCollection), nil
+}
+
+func (s *mongoStore) snapshots() (*mongo.Collection, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	return db.Collection(configs.MongoSnapshotCollection), nil
+}
+
+func (s *mongoStore) discrepancies() (*mongo.Collection, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	return db.Collection(configs.MongoDiscrepancyCollection), nil
+}
+
+func (s *mongoStore) tokens() (*mongo.Collection, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	return db.Collection(configs.MongoTokenCollection), nil
+}
+
+func (s *mongoStore) checkpoints() (*mongo.Collection, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	return db.Collection(configs.MongoCheckpointCollection), nil
+}
+
+func (s *mongoStore) Migrate(ctx context.Context) error {
+	collection, err := s.transfers()
+	if err != nil {
+		return err
+	}
+
+	// Transfers stored before batches were split have no batchindex.
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"batchindex": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"batchindex": 0}})
+	if err != nil {
+		return err
+	}
+
+	// A single ERC-1155 TransferBatch log yields several transfers, told apart
+	// by the batch index.
+	err = database.CreateUniqueIndex(configs.MongoCollection, bson.D{
+		{Key: "chainid", Value: 1},
+		{Key: "txhash", Value: 1},
+		{Key: "index", Value: 1},
+		{Key: "batchindex", Value: 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Transfers stored before values were kept as strings lost their value
+	// and have no value key, so value filters never match them.
+	_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "valuekey", Value: -1}}},
+		{Keys: bson.D{{Key: "balancestate", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = database.CreateUniqueIndex(configs.MongoTokenCollection, bson.D{{Key: "chainid", Value: 1}, {Key: "address", Value: 1}})
+	if err != nil {
+		return err
+	}
+
+	err = database.CreateUniqueIndex(configs.MongoDiscrepancyCollection, bson.D{
+		{Key: "chainid", Value: 1},
+		{Key: "kind", Value: 1},
+		{Key: "contractaddress", Value: 1},
+		{Key: "account", Value: 1},
+		{Key: "blocknumber", Value: 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = database.CreateUniqueIndex(configs.MongoSnapshotCollection, bson.D{{Key: "chainid", Value: 1}, {Key: "account", Value: 1}, {Key: "blocknumber", Value: -1}})
+	if err != nil {
+		return err
+	}
+
+	return s.migrateBalances(ctx)
+}
+
+func (s *mongoStore) migrateBalances(ctx context.Context) error {
+	err := database.CreateUniqueIndex(configs.MongoBalanceCollection, bson.D{
+		{Key: "chainid", Value: 1},
+		{Key: "contractaddress", Value: 1},
+		{Key: "tokenid", Value: 1},
+		{Key: "account", Value: 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	balances, err := s.balances()
+	if err != nil {
+		return err
+	}
+
+	_, err = balances.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "account", Value: 1}, {Key: "balancekey", Value: -1}}},
+		{Keys: bson.D{{Key: "contractaddress", Value: 1}, {Key: "balancekey", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.migrateNegativeBalanceKeys(ctx, balances)
+	if err != nil {
+		return err
+	}
+
+	transfers, err := s.transfers()
+	if err != nil {
+		return err
+	}
+
+	// Without transactions, a transfer left applying may have had its balance
+	// changes partly written. Applying it again may count it twice, but
+	// leaving it would never count it.
+	result, err := transfers.UpdateMany(ctx,
+		bson.M{"balancestate": balanceApplying},
+		bson.M{"$set": bson.M{"balancestate": balancePending}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		logger.Logger.Warnf("[Storage] Applying again the balance changes of %v interrupted transfers", result.ModifiedCount)
+	}
+
+	count, err := balances.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return s.applyPendingTransfers(ctx, transfers, bson.M{})
+	}
+
+	err = s.rebuildBalances(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = transfers.UpdateMany(ctx,
+		bson.M{"balancestate": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"balancestate": ""}})
+	return err
+}
+
+// migrateNegativeBalanceKeys rewrites the keys of negative balances stored
+// when they held the absolute value, which sorted -5 above -1.
+func (s *mongoStore) migrateNegativeBalanceKeys(ctx context.Context, balances *mongo.Collection) error {
+	cursor, err := balances.Find(ctx, bson.M{"balancekey": bson.M{"$regex": "^-"}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		balance := &mongoBalance{}
+		if err := cursor.Decode(balance); err != nil {
+			return err
+		}
+
+		key := BalanceKey(balance.Balance)
+		if key == balance.BalanceKey {
+			continue
+		}
+
+		_, err = balances.UpdateOne(ctx,
+			bson.M{"_id": cursor.Current.Lookup("_id"), "balancekey": balance.BalanceKey},
+			bson.M{"$set": bson.M{"balancekey": key}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// rebuildBalances adds up the balances of the transfers stored before
+// balances were maintained.
+func (s *mongoStore) rebuildBalances(ctx context.Context) error {
+	collection, err := s.transfers()
+	if err != nil {
+		return err
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	changes := make(map[balanceKey]*balanceChange)
+	for cursor.Next(ctx) {
+		transfer := &TransferLog{}
+		if err := cursor.Decode(transfer); err != nil {
+			return err
+		}
+		addBalanceChanges(changes, []*TransferLog{transfer}, false)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	return s.applyBalanceChanges(ctx, sortBalanceChanges(changes))
+}
+
+// applyBalanceChanges updates each balance with a compare-and-swap on its
+// previous value, retrying when another writer changed it in between.
+// Balances that drop to zero are removed.
+func (s *mongoStore) applyBalanceChanges(ctx context.Context, changes []*balanceChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	balances, err := s.balances()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		filter := bson.M{
+			"chainid":         change.key.chainID,
+			"contractaddress": change.key.contract,
+			"tokenid":         change.key.tokenID,
+			"account":         change.key.account,
+		}
+
+		for {
+			current := &mongoBalance{}
+			err := balances.FindOne(ctx, filter).Decode(current)
+			if err == mongo.ErrNoDocuments {
+				// An upsert only inserts when no other writer created the
+				// balance in between, and conflicts instead of failing inside
+				// a transaction.
+				insert := bson.M{"$setOnInsert": &mongoBalance{
+					ChainID:         change.key.chainID,
+					ContractAddress: change.key.contract,
+					Standard:        change.standard,
+					TokenID:         change.key.tokenID,
+					Account:         change.key.account,
+					Balance:         change.delta,
+					BalanceKey:      BalanceKey(change.delta),
+				}}
+				result, err := balances.UpdateOne(ctx, filter, insert, options.Update().SetUpsert(true))
+				if mongo.IsDuplicateKeyError(err) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if result.UpsertedCount > 0 {
+					break
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			swapFilter := bson.M{"balance": bigIntString(current.Balance)}
+			for key, value := range filter {
+				swapFilter[key] = value
+			}
+
+			balance := new(big.Int).Add(current.Balance, change.delta)
+
+			var matched int64
+			if balance.Sign() == 0 {
+				result, err := balances.DeleteOne(ctx, swapFilter)
+				if err != nil {
+					return err
+				}
+				matched = result.DeletedCount
+			} else {
+				update := bson.M{"$set": bson.M{"balance": balance, "balancekey": BalanceKey(balance)}}
+				result, err := balances.UpdateOne(ctx, swapFilter, update)
+				if err != nil {
+					return err
+				}
+				matched = result.MatchedCount
+			}
+			if matched > 0 {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *mongoStore) InsertTransfers(ctx context.Context, transfers []*TransferLog) ([]*TransferLog, error) {
+	if len(transfers) == 0 {
+		return nil, nil
+	}
+
+	collection, err := s.transfers()
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]interface{}, len(transfers))
+	chainIDs := make(map[uint64]bool)
+	var txHashes bson.A
+	for i, transfer := range transfers {
+		documents[i] = &mongoTransfer{TransferLog: *transfer, ValueKey: ValueKey(transfer.Value), BalanceState: balancePending}
+		chainIDs[transfer.ChainID] = true
+		txHashes = append(txHashes, transfer.TxHash)
+	}
+
+	_, err = collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+
+	duplicates, err := database.DuplicateIndexes(err)
+	if err != nil {
+		return nil, err
+	}
+
+	var inserted []*TransferLog
+	for i, transfer := range transfers {
+		if !duplicates[i] {
+			inserted = append(inserted, transfer)
+		}
+	}
+
+	// Duplicates are applied too when an earlier write stored them but did
+	// not get to their balances.
+	var chains bson.A
+	for chainID := range chainIDs {
+		chains = append(chains, chainID)
+	}
+	err = s.applyPendingTransfers(ctx, collection, bson.M{"chainid": bson.M{"$in": chains}, "txhash": bson.M{"$in": txHashes}})
+	if err != nil {
+		return nil, err
+	}
+
+	return inserted, nil
+}
+
+// applyPendingTransfers applies the balance changes of the matching pending
+// transfers, in a transaction when the deployment supports them. Each transfer
+// is claimed first, so that writers storing the same transfers concurrently
+// apply it once.
+func (s *mongoStore) applyPendingTransfers(ctx context.Context, collection *mongo.Collection, filter bson.M) error {
+	filter = AndFilters(filter, bson.M{"balancestate": balancePending})
+
+	return database.WithTransaction(ctx, func(ctx context.Context) error {
+		cursor, err := collection.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		var stored []storedTransfer
+		if err := cursor.All(ctx, &stored); err != nil {
+			return err
+		}
+
+		var claimed []*TransferLog
+		var ids bson.A
+		for i := range stored {
+			result, err := collection.UpdateOne(ctx,
+				bson.M{"_id": stored[i].ID, "balancestate": balancePending},
+				bson.M{"$set": bson.M{"balancestate": balanceApplying}})
+			if err != nil {
+				return err
+			}
+			if result.ModifiedCount > 0 {
+				claimed = append(claimed, &stored[i].TransferLog)
+				ids = append(ids, stored[i].ID)
+			}
+		}
+		if len(claimed) == 0 {
+			return nil
+		}
+
+		err = s.applyBalanceChanges(ctx, balanceChanges(claimed, false))
+		if err != nil {
+			return err
+		}
+
+		// Backfills and rescans insert transfers below snapshots already
+		// taken.
+		err = s.deleteSnapshots(ctx, claimed)
+		if err != nil {
+			return err
+		}
+
+		_, err = collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$unset": bson.M{"balancestate": ""}})
+		return err
+	})
+}
+
+func (s *mongoStore) FindTransfers(ctx context.Context, filter TransferFilter, offset int64, limit int64) ([]*TransferLog, error) {
+	collection, err := s.transfers()
+	if err != nil {
+		return nil, err
+	}
+
+	// The same order as the SQL backends, so pages are stable across blocks
+	// with several transfers.
+	sort := bson.D{{Key: "blocknumber", Value: 1}, {Key: "index", Value: 1}, {Key: "batchindex", Value: 1}}
+	if filter.Sort == TransferSortValue {
+		sort = append(bson.D{{Key: "valuekey", Value: -1}}, sort...)
+	}
+
+	queryOptions := options.Find().SetSort(sort).SetSkip(offset).SetLimit(limit)
+
+	cursor, err := collection.Find(ctx, MongoTransferFilter(filter), queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	transfers := []*TransferLog{}
+	if err := cursor.All(ctx, &transfers); err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+func (s *mongoStore) CountTransfers(ctx context.Context, filter TransferFilter) (int64, error) {
+	collection, err := s.transfers()
+	if err != nil {
+		return 0, err
+	}
+
+	return collection.CountDocuments(ctx, MongoTransferFilter(filter))
+}
+
+// Decimal128 holds 34 digits, which leaves room to sum up to 10^8 parts of 26
+// digits.
+const mongoValuePartDigits = 26
+
+type mongoVolumeBucket struct {
+	Time      int64 `bson:"_id"`
+	Transfers int64
+	Senders   int64
+	Receivers int64
+	Value     []primitive.Decimal128
+}
+
+func (s *mongoStore) TransferVolume(ctx context.Context, filter TransferFilter, interval uint64) ([]*VolumeBucket, error) {
+	collection, err := s.transfers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Transfers stored before values were kept as strings have no value key
+	// and count as zero.
+	valueKey := bson.M{"$ifNull": bson.A{"$valuekey", ValueKey(nil)}}
+
+	group := bson.M{
+		"_id":       bson.M{"$subtract": bson.A{"$blocktimestamp", bson.M{"$mod": bson.A{"$blocktimestamp", int64(interval)}}}},
+		"transfers": bson.M{"$sum": 1},
+		"senders":   bson.M{"$addToSet": "$from"},
+		"receivers": bson.M{"$addToSet": "$to"},
+	}
+	var parts bson.A
+	for i := 0; i < valueDigits/mongoValuePartDigits; i++ {
+		field := fmt.Sprintf("value%d", i)
+		part := bson.M{"$substrBytes": bson.A{valueKey, i * mongoValuePartDigits, mongoValuePartDigits}}
+		group[field] = bson.M{"$sum": bson.M{"$toDecimal": part}}
+		parts = append(parts, "$"+field)
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": MongoTransferFilter(filter)},
+		bson.M{"$group": group},
+		bson.M{"$project": bson.M{
+			"transfers": 1,
+			"senders":   bson.M{"$size": "$senders"},
+			"receivers": bson.M{"$size": "$receivers"},
+			"value":     parts,
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stored []*mongoVolumeBucket
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+
+	buckets := make([]*VolumeBucket, len(stored))
+	for i, bucket := range stored {
+		values := make([]*big.Int, len(bucket.Value))
+		for j, part := range bucket.Value {
+			value, exponent, err := part.BigInt()
+			if err != nil {
+				return nil, err
+			}
+			values[j] = value.Mul(value, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil))
+		}
+
+		buckets[i] = &VolumeBucket{
+			Time:      uint64(bucket.Time),
+			Transfers: bucket.Transfers,
+			Value:     joinValueKeyParts(values, mongoValuePartDigits),
+			Senders:   bucket.Senders,
+			Receivers: bucket.Receivers,
+		}
+	}
+
+	return buckets, nil
+}
+
+func (s *mongoStore) RemoveTransfers(ctx context.Context, chainID uint64, txHash common.Hash, index uint, blockHash common.Hash) (int64, error) {
+	collection, err := s.transfers()
+	if err != nil {
+		return 0, err
+	}
+
+	filter := bson.M{"chainid": chainID, "txhash": txHash, "index": index, "blockhash": blockHash}
+	return s.removeTransfers(ctx, collection, filter)
+}
+
+func (s *mongoStore) RemoveOrphanedTransfers(ctx context.Context, chainID uint64, blockNumber uint64, canonicalHash common.Hash) (int64, error) {
+	collection, err := s.transfers()
+	if err != nil {
+		return 0, err
+	}
+
+	filter := bson.M{"chainid": chainID, "blocknumber": blockNumber, "blockhash": bson.M{"$ne": canonicalHash}}
+	return s.removeTransfers(ctx, collection, filter)
+}
+
+// removeTransfers deletes the matching transfers one by one, so that only the
+// balances of the transfers it deleted are reversed. Pending transfers never
+// changed the balances and are deleted as they are.
+func (s *mongoStore) removeTransfers(ctx context.Context, collection *mongo.Collection, filter bson.M) (int64, error) {
+	var removed []*TransferLog
+	err := database.WithTransaction(ctx, func(ctx context.Context) error {
+		cursor, err := collection.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		var stored []storedTransfer
+		if err := cursor.All(ctx, &stored); err != nil {
+			return err
+		}
+
+		removed = nil
+		var applied []*TransferLog
+		for i := range stored {
+			result, err := collection.DeleteOne(ctx, bson.M{"_id": stored[i].ID, "balancestate": balancePending})
+			if err != nil {
+				return err
+			}
+			if result.DeletedCount > 0 {
+				removed = append(removed, &stored[i].TransferLog)
+				continue
+			}
+
+			result, err = collection.DeleteOne(ctx, bson.M{"_id": stored[i].ID})
+			if err != nil {
+				return err
+			}
+			if result.DeletedCount > 0 {
+				removed = append(removed, &stored[i].TransferLog)
+				applied = append(applied, &stored[i].TransferLog)
+			}
+		}
+
+		err = s.applyBalanceChanges(ctx, balanceChanges(applied, true))
+		if err != nil {
+			return err
+		}
+
+		return s.deleteSnapshots(ctx, removed)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(removed)), nil
+}
+
+// deleteSnapshots drops the snapshots the transfers make stale.
+func (s *mongoStore) deleteSnapshots(ctx context.Context, transfers []*TransferLog) error {
+	snapshots, err := s.snapshots()
+	if err != nil {
+		return err
+	}
+
+	for chainID, blockNumber := range firstBlocks(transfers) {
+		_, err := snapshots.DeleteMany(ctx, bson.M{"chainid": chainID, "blocknumber": bson.M{"$gte": blockNumber}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mongoStore) ConfirmTransfers(ctx context.Context, chainID uint64, upToBlock uint64) (int64, error) {
+	collection, err := s.transfers()
+	if err != nil {
+		return 0, err
+	}
+
+	filter := bson.M{"chainid": chainID, "status": TransferStatusPending, "blocknumber": bson.M{"$lte": upToBlock}}
+	update := bson.M{"$set": bson.M{"status": TransferStatusConfirmed}}
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+func (s *mongoStore) FindBalances(ctx context.Context, filter BalanceFilter, offset int64, limit int64) ([]*Balance, error) {
+	balances, err := s.balances()
+	if err != nil {
+		return nil, err
+	}
+
+	direction := -1
+	if filter.Ascending {
+		direction = 1
+	}
+
+	sort := bson.D{{Key: "balancekey", Value: direction}, {Key: "contractaddress", Value: 1}, {Key: "tokenid", Value: 1}, {Key: "account", Value: 1}}
+	queryOptions := options.Find().SetSort(sort).SetSkip(offset).SetLimit(limit)
+
+	cursor, err := balances.Find(ctx, mongoBalanceFilter(filter), queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	return decodeBalances(ctx, cursor)
+}
+
+func (s *mongoStore) SampleBalances(ctx context.Context, filter BalanceFilter, size int64) ([]*Balance, error) {
+	balances, err := s.balances()
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": mongoBalanceFilter(filter)},
+		bson.M{"$sample": bson.M{"size": size}},
+	}
+
+	cursor, err := balances.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	return decodeBalances(ctx, cursor)
+}
+
+func decodeBalances(ctx context.Context, cursor *mongo.Cursor) ([]*Balance, error) {
+	var stored []*mongoBalance
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+
+	result := make([]*Balance, len(stored))
+	for i, balance := range stored {
+		result[i] = &Balance{
+			ChainID:         balance.ChainID,
+			ContractAddress: balance.ContractAddress,
+			Standard:        balance.Standard,
+			TokenID:         parseTokenID(balance.TokenID),
+			Account:         balance.Account,
+			Balance:         balance.Balance,
+		}
+	}
+
+	return result, nil
+}
+
+func (s *mongoStore) CountBalances(ctx context.Context, filter BalanceFilter) (int64, error) {
+	balances, err := s.balances()
+	if err != nil {
+		return 0, err
+	}
+
+	return balances.CountDocuments(ctx, mongoBalanceFilter(filter))
+}
+
+func (s *mongoStore) CountHolders(ctx context.Context, filter BalanceFilter) (int64, error) {
+	balances, err := s.balances()
+	if err != nil {
+		return 0, err
+	}
+
+	filter.Positive = true
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: mongoBalanceFilter(filter)}},
+		{{Key: "$group", Value: bson.M{"_id": "$account"}}},
+		{{Key: "$count", Value: "holders"}},
+	}
+
+	cursor, err := balances.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Holders int64 `bson:"holders"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Holders, cursor.Err()
+}
+
+func mongoBalanceFilter(filter BalanceFilter) bson.M {
+	query := bson.M{}
+	if filter.ChainID != nil {
+		query["chainid"] = *filter.ChainID
+	}
+	if filter.Account != nil {
+		query["account"] = *filter.Account
+	}
+	if filter.Contract != nil {
+		query["contractaddress"] = *filter.Contract
+	}
+	if filter.TokenID != nil {
+		query["tokenid"] = filter.TokenID.String()
+	}
+	if filter.Standard != "" {
+		query["standard"] = filter.Standard
+	}
+	if filter.Positive {
+		query["balancekey"] = bson.M{"$gte": ValueKey(big.NewInt(1))}
+	}
+	return query
+}
+
+func (s *mongoStore) GetBalanceSnapshot(ctx context.Context, chainID uint64, account common.Address, blockNumber uint64) (*BalanceSnapshot, error) {
+	snapshots, err := s.snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"chainid": chainID, "account": account, "blocknumber": bson.M{"$lte": blockNumber}}
+	queryOptions := options.FindOne().SetSort(bson.D{{Key: "blocknumber", Value: -1}})
+
+	snapshot := &BalanceSnapshot{}
+	err = snapshots.FindOne(ctx, filter, queryOptions).Decode(snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+func (s *mongoStore) SaveBalanceSnapshot(ctx context.Context, snapshot *BalanceSnapshot) error {
+	snapshots, err := s.snapshots()
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"chainid": snapshot.ChainID, "account": snapshot.Account, "blocknumber": snapshot.BlockNumber}
+	_, err = snapshots.ReplaceOne(ctx, filter, snapshot, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) GetToken(ctx context.Context, chainID uint64, address common.Address) (*Token, error) {
+	tokens, err := s.tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{}
+	err = tokens.FindOne(ctx, bson.M{"chainid": chainID, "address": address}).Decode(token)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *mongoStore) SaveToken(ctx context.Context, token *Token) error {
+	tokens, err := s.tokens()
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"chainid": token.ChainID, "address": token.Address}
+	_, err = tokens.ReplaceOne(ctx, filter, token, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) FindTokens(ctx context.Context, chainID *uint64, offset int64, limit int64) ([]*Token, error) {
+	tokens, err := s.tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{}
+	if chainID != nil {
+		filter["chainid"] = *chainID
+	}
+
+	queryOptions := options.Find().SetSort(bson.D{{Key: "chainid", Value: 1}, {Key: "address", Value: 1}}).SetSkip(offset).SetLimit(limit)
+
+	cursor, err := tokens.Find(ctx, filter, queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := []*Token{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *mongoStore) SaveDiscrepancy(ctx context.Context, discrepancy *Discrepancy) error {
+	discrepancies, err := s.discrepancies()
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"chainid":         discrepancy.ChainID,
+		"kind":            discrepancy.Kind,
+		"contractaddress": discrepancy.ContractAddress,
+		"account":         discrepancy.Account,
+		"blocknumber":     discrepancy.BlockNumber,
+	}
+	_, err = discrepancies.ReplaceOne(ctx, filter, discrepancy, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) FindDiscrepancies(ctx context.Context, chainID *uint64, offset int64, limit int64) ([]*Discrepancy, error) {
+	discrepancies, err := s.discrepancies()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{}
+	if chainID != nil {
+		filter["chainid"] = *chainID
+	}
+
+	queryOptions := options.Find().SetSort(bson.D{{Key: "detectedat", Value: -1}, {Key: "blocknumber", Value: -1}}).SetSkip(offset).SetLimit(limit)
+
+	cursor, err := discrepancies.Find(ctx, filter, queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := []*Discrepancy{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func checkpointFilter(chainID uint64, collection string) bson.M {
+	return bson.M{"chainid": chainID, "collection": collection}
+}
+
+func (s *mongoStore) GetCheckpoint(ctx context.Context, chainID uint64, collection string) (*Checkpoint, error) {
+	checkpoints, err := s.checkpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{}
+	err = checkpoints.FindOne(ctx, checkpointFilter(chainID, collection)).Decode(checkpoint)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+func (s *mongoStore) SaveCheckpoint(ctx context.Context, chainID uint64, collection string, blockNumber uint64) error {
+	checkpoints, err := s.checkpoints()
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$max": bson.M{"blocknumber": blockNumber},
+		"$set": bson.M{"updatedat": time.Now().Unix()},
+	}
+
+	_, err = checkpoints.UpdateOne(ctx, checkpointFilter(chainID, collection), update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) ResetCheckpoint(ctx context.Context, chainID uint64, collection string, blockNumber uint64) error {
+	checkpoints, err := s.checkpoints()
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{"blocknumber": blockNumber, "updatedat": time.Now().Unix()},
+	}
+
+	_, err = checkpoints.UpdateOne(ctx, checkpointFilter(chainID, collection), update, options.Update().SetUpsert(true))
+	return err
+}
+
+// AndFilters combines the non-empty filters into a single MongoDB filter.
+func AndFilters(filters ...bson.M) bson.M {
+	var conditions []bson.M
+	for _, filter := range filters {
+		if len(filter) > 0 {
+			conditions = append(conditions, filter)
+		}
+	}
+
+	switch len(conditions) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return conditions[0]
+	default:
+		return bson.M{"$and": conditions}
+	}
+}
+
+func rangeFilter(field string, from *uint64, to *uint64) bson.M {
+	valueRange := bson.M{}
+	if from != nil {
+		valueRange["$gte"] = *from
+	}
+	if to != nil {
+		valueRange["$lte"] = *to
+	}
+
+	if len(valueRange) == 0 {
+		return nil
+	}
+	return bson.M{field: valueRange}
+}
+
+// MongoTransferFilter translates filter into a MongoDB filter. Events stored
+// in MongoDB share the chain, contract, block and status fields of transfers,
+// so they are filtered the same way.
+func MongoTransferFilter(filter TransferFilter) bson.M {
+	var conditions []bson.M
+
+	if filter.ChainID != nil {
+		conditions = append(conditions, bson.M{"chainid": *filter.ChainID})
+	}
+
+	if filter.Address != nil {
+		conditions = append(conditions, bson.M{
+			"$or": []bson.M{
+				{"from": *filter.Address},
+				{"to": *filter.Address},
+				{"contractaddress": *filter.Address},
+			},
+		})
+	}
+
+	if filter.Contract != nil {
+		conditions = append(conditions, bson.M{"contractaddress": *filter.Contract})
+	}
+
+	conditions = append(conditions,
+		rangeFilter("blocknumber", filter.FromBlock, filter.ToBlock),
+		rangeFilter("blocktimestamp", filter.FromTime, filter.ToTime),
+	)
+
+	valueRange := bson.M{}
+	if filter.MinValue != nil {
+		valueRange["$gte"] = ValueKey(filter.MinValue)
+	}
+	if filter.MaxValue != nil {
+		valueRange["$lte"] = ValueKey(filter.MaxValue)
+	}
+	if len(valueRange) > 0 {
+		conditions = append(conditions, bson.M{"valuekey": valueRange})
+	}
+
+	// Transfers stored before confirmations were tracked have no status and
+	// are treated as confirmed.
+	switch filter.Status {
+	case TransferStatusPending:
+		conditions = append(conditions, bson.M{"status": TransferStatusPending})
+	case TransferStatusConfirmed:
+		conditions = append(conditions, bson.M{"status": bson.M{"$ne": TransferStatusPending}})
+	}
+
+	// Transfers stored before token standards were tracked are all ERC-20.
+	switch filter.Standard {
+	case "":
+	case TokenStandardERC20:
+		conditions = append(conditions, bson.M{"standard": bson.M{"$in": bson.A{TokenStandardERC20, nil}}})
+	default:
+		conditions = append(conditions, bson.M{"standard": filter.Standard})
+	}
+
+	return AndFilters(conditions...)
+}
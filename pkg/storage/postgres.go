@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	_ "github.com/lib/pq"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+var postgresDialect = sqlDialect{
+	placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	greatest:    "GREATEST",
+	forUpdate:   " FOR UPDATE",
+	// 0005 creates the balances.
+	balanceRebuilds: map[int]bool{5: true},
+}
+
+func openPostgres(dsn string) (Store, error) {
+	if dsn == "" {
+		return nil, errors.New("postgresDSN is required for the postgres storage")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
+	}
+
+	migrations, err := fs.Sub(postgresMigrations, "migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlStore{db: db, dialect: postgresDialect, migrations: migrations}, nil
+}
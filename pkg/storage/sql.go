@@ -0,0 +1,910 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// sqlDialect holds what differs between the SQL databases sqlStore runs on.
+type sqlDialect struct {
+	// placeholder returns the bind parameter of the n-th argument, counted
+	// from 1.
+	placeholder func(n int) string
+	// greatest is the function returning the largest of its arguments.
+	greatest string
+	// forUpdate locks the rows a SELECT returns until the transaction ends.
+	forUpdate string
+	// balanceRebuilds lists the migrations after which the balances are
+	// added up from the stored transfers, within the same transaction.
+	balanceRebuilds map[int]bool
+}
+
+// sqlStore keeps transfers and checkpoints in a SQL database. The schema is
+// created by the numbered migrations in migrations.
+type sqlStore struct {
+	db         *sql.DB
+	dialect    sqlDialect
+	migrations fs.FS
+}
+
+const transferColumns = `chain_id, from_address, to_address, value, contract_address, block_number, block_hash,
+	tx_hash, tx_index, log_index, block_timestamp, status, standard, token_id, operator, batch_index`
+
+// sqlQuery collects conditions and their arguments, binding each argument
+// with the placeholder of the dialect.
+type sqlQuery struct {
+	dialect    sqlDialect
+	conditions []string
+	args       []interface{}
+}
+
+func (q *sqlQuery) bind(value interface{}) string {
+	q.args = append(q.args, value)
+	return q.dialect.placeholder(len(q.args))
+}
+
+func (q *sqlQuery) where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+func (q *sqlQuery) whereBalance(key balanceKey) {
+	q.where("chain_id = " + q.bind(key.chainID))
+	q.where("contract_address = " + q.bind(key.contract.Hex()))
+	q.where("token_id = " + q.bind(key.tokenID))
+	q.where("account = " + q.bind(key.account.Hex()))
+}
+
+func (q *sqlQuery) whereClause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+func (s *sqlStore) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int]bool)
+	rows, err := s.db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(s.migrations, "*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		// Migrations are named <version>_<description>.sql.
+		version, err := strconv.Atoi(strings.SplitN(path.Base(file), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("invalid migration name %q", file)
+		}
+		if applied[version] {
+			continue
+		}
+
+		statements, err := fs.ReadFile(s.migrations, file)
+		if err != nil {
+			return err
+		}
+
+		if err := s.applyMigration(ctx, version, string(statements)); err != nil {
+			return fmt.Errorf("failed to apply migration %q: %v", file, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *sqlStore) applyMigration(ctx context.Context, version int, statements string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+
+	if s.dialect.balanceRebuilds[version] {
+		if err := s.rebuildBalances(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	query := &sqlQuery{dialect: s.dialect}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO schema_migrations (version, applied_at) VALUES (%s, %s)`,
+		query.bind(version), query.bind(time.Now().Unix())), query.args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) InsertTransfers(ctx context.Context, transfers []*TransferLog) ([]*TransferLog, error) {
+	if len(transfers) == 0 {
+		return nil, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	placeholders := make([]string, 17)
+	for i := range placeholders {
+		placeholders[i] = s.dialect.placeholder(i + 1)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO transfers (%s, value_key) VALUES (%s) ON CONFLICT DO NOTHING`,
+		transferColumns, strings.Join(placeholders, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var inserted []*TransferLog
+	for _, transfer := range transfers {
+		var tokenID sql.NullString
+		if transfer.TokenID != nil {
+			tokenID = sql.NullString{String: transfer.TokenID.String(), Valid: true}
+		}
+
+		result, err := stmt.ExecContext(ctx,
+			transfer.ChainID,
+			transfer.From.Hex(),
+			transfer.To.Hex(),
+			bigIntString(transfer.Value),
+			transfer.ContractAddress.Hex(),
+			transfer.BlockNumber,
+			transfer.BlockHash.Hex(),
+			transfer.TxHash.Hex(),
+			transfer.TxIndex,
+			transfer.Index,
+			transfer.BlockTimeStamp,
+			transfer.Status,
+			transfer.Standard,
+			tokenID,
+			transfer.Operator.Hex(),
+			transfer.BatchIndex,
+			ValueKey(transfer.Value),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if affected > 0 {
+			inserted = append(inserted, transfer)
+		}
+	}
+
+	err = s.applyBalanceChanges(ctx, tx, balanceChanges(inserted, false))
+	if err != nil {
+		return nil, err
+	}
+
+	// Backfills and rescans insert transfers below snapshots already taken.
+	err = s.deleteSnapshots(ctx, tx, inserted)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return inserted, nil
+}
+
+func bigIntString(value *big.Int) string {
+	if value == nil {
+		return "0"
+	}
+	return value.String()
+}
+
+func (s *sqlStore) transferQuery(filter TransferFilter) *sqlQuery {
+	query := &sqlQuery{dialect: s.dialect}
+
+	if filter.ChainID != nil {
+		query.where("chain_id = " + query.bind(*filter.ChainID))
+	}
+
+	if filter.Address != nil {
+		address := filter.Address.Hex()
+		query.where(fmt.Sprintf("(from_address = %s OR to_address = %s OR contract_address = %s)",
+			query.bind(address), query.bind(address), query.bind(address)))
+	}
+
+	if filter.Contract != nil {
+		query.where("contract_address = " + query.bind(filter.Contract.Hex()))
+	}
+
+	if filter.FromBlock != nil {
+		query.where("block_number >= " + query.bind(*filter.FromBlock))
+	}
+	if filter.ToBlock != nil {
+		query.where("block_number <= " + query.bind(*filter.ToBlock))
+	}
+	if filter.FromTime != nil {
+		query.where("block_timestamp >= " + query.bind(*filter.FromTime))
+	}
+	if filter.ToTime != nil {
+		query.where("block_timestamp <= " + query.bind(*filter.ToTime))
+	}
+
+	if filter.MinValue != nil {
+		query.where("value_key >= " + query.bind(ValueKey(filter.MinValue)))
+	}
+	if filter.MaxValue != nil {
+		query.where("value_key <= " + query.bind(ValueKey(filter.MaxValue)))
+	}
+
+	switch filter.Status {
+	case TransferStatusPending:
+		query.where("status = " + query.bind(TransferStatusPending))
+	case TransferStatusConfirmed:
+		query.where("status <> " + query.bind(TransferStatusPending))
+	}
+
+	if filter.Standard != "" {
+		query.where("standard = " + query.bind(filter.Standard))
+	}
+
+	return query
+}
+
+func (s *sqlStore) FindTransfers(ctx context.Context, filter TransferFilter, offset int64, limit int64) ([]*TransferLog, error) {
+	query := s.transferQuery(filter)
+
+	order := "block_number, log_index, batch_index"
+	if filter.Sort == TransferSortValue {
+		order = "value_key DESC, " + order
+	}
+
+	statement := fmt.Sprintf(`SELECT %s FROM transfers%s ORDER BY %s LIMIT %s OFFSET %s`,
+		transferColumns, query.whereClause(), order, query.bind(limit), query.bind(offset))
+
+	rows, err := s.db.QueryContext(ctx, statement, query.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []*TransferLog{}
+	for rows.Next() {
+		transfer, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, rows.Err()
+}
+
+func scanTransfer(rows *sql.Rows) (*TransferLog, error) {
+	var from, to, value, contract, blockHash, txHash, operator string
+	var tokenID sql.NullString
+
+	transfer := &TransferLog{}
+	err := rows.Scan(
+		&transfer.ChainID,
+		&from,
+		&to,
+		&value,
+		&contract,
+		&transfer.BlockNumber,
+		&blockHash,
+		&txHash,
+		&transfer.TxIndex,
+		&transfer.Index,
+		&transfer.BlockTimeStamp,
+		&transfer.Status,
+		&transfer.Standard,
+		&tokenID,
+		&operator,
+		&transfer.BatchIndex,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer.From = common.HexToAddress(from)
+	transfer.To = common.HexToAddress(to)
+	transfer.ContractAddress = common.HexToAddress(contract)
+	transfer.BlockHash = common.HexToHash(blockHash)
+	transfer.TxHash = common.HexToHash(txHash)
+	transfer.Operator = common.HexToAddress(operator)
+
+	var ok bool
+	transfer.Value, ok = new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid transfer value %q", value)
+	}
+
+	if tokenID.Valid {
+		transfer.TokenID, ok = new(big.Int).SetString(tokenID.String, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid token id %q", tokenID.String)
+		}
+	}
+
+	return transfer, nil
+}
+
+func (s *sqlStore) CountTransfers(ctx context.Context, filter TransferFilter) (int64, error) {
+	query := s.transferQuery(filter)
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM transfers`+query.whereClause(), query.args...).Scan(&count)
+	return count, err
+}
+
+// SQLite sums 64-bit integers, which leaves room to sum up to 10^12 parts of
+// 6 digits.
+const sqlValuePartDigits = 6
+
+func (s *sqlStore) TransferVolume(ctx context.Context, filter TransferFilter, interval uint64) ([]*VolumeBucket, error) {
+	query := s.transferQuery(filter)
+
+	parts := valueDigits / sqlValuePartDigits
+	var sums []string
+	for i := 0; i < parts; i++ {
+		sums = append(sums, fmt.Sprintf("SUM(CAST(substr(value_key, %d, %d) AS BIGINT))", i*sqlValuePartDigits+1, sqlValuePartDigits))
+	}
+
+	statement := fmt.Sprintf(`SELECT block_timestamp - block_timestamp %% %d, COUNT(*),
+		COUNT(DISTINCT from_address), COUNT(DISTINCT to_address), %s
+		FROM transfers%s GROUP BY 1 ORDER BY 1`,
+		interval, strings.Join(sums, ", "), query.whereClause())
+
+	rows, err := s.db.QueryContext(ctx, statement, query.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []*VolumeBucket{}
+	for rows.Next() {
+		bucket := &VolumeBucket{}
+
+		partSums := make([]int64, parts)
+		dest := []interface{}{&bucket.Time, &bucket.Transfers, &bucket.Senders, &bucket.Receivers}
+		for i := range partSums {
+			dest = append(dest, &partSums[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		values := make([]*big.Int, parts)
+		for i, sum := range partSums {
+			values[i] = big.NewInt(sum)
+		}
+		bucket.Value = joinValueKeyParts(values, sqlValuePartDigits)
+
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, rows.Err()
+}
+
+func (s *sqlStore) RemoveTransfers(ctx context.Context, chainID uint64, txHash common.Hash, index uint, blockHash common.Hash) (int64, error) {
+	query := &sqlQuery{dialect: s.dialect}
+	query.where("chain_id = " + query.bind(chainID))
+	query.where("tx_hash = " + query.bind(txHash.Hex()))
+	query.where("log_index = " + query.bind(index))
+	query.where("block_hash = " + query.bind(blockHash.Hex()))
+
+	return s.removeTransfers(ctx, query)
+}
+
+func (s *sqlStore) RemoveOrphanedTransfers(ctx context.Context, chainID uint64, blockNumber uint64, canonicalHash common.Hash) (int64, error) {
+	query := &sqlQuery{dialect: s.dialect}
+	query.where("chain_id = " + query.bind(chainID))
+	query.where("block_number = " + query.bind(blockNumber))
+	query.where("block_hash <> " + query.bind(canonicalHash.Hex()))
+
+	return s.removeTransfers(ctx, query)
+}
+
+// removeTransfers deletes the matching transfers and reverses their balances
+// in one transaction.
+func (s *sqlStore) removeTransfers(ctx context.Context, query *sqlQuery) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+transferColumns+` FROM transfers`+query.whereClause()+s.dialect.forUpdate, query.args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed []*TransferLog
+	for rows.Next() {
+		transfer, err := scanTransfer(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		removed = append(removed, transfer)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM transfers`+query.whereClause(), query.args...)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.applyBalanceChanges(ctx, tx, balanceChanges(removed, true))
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.deleteSnapshots(ctx, tx, removed)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int64(len(removed)), nil
+}
+
+// deleteSnapshots drops within tx the snapshots the transfers make stale.
+func (s *sqlStore) deleteSnapshots(ctx context.Context, tx *sql.Tx, transfers []*TransferLog) error {
+	for chainID, blockNumber := range firstBlocks(transfers) {
+		query := &sqlQuery{dialect: s.dialect}
+		query.where("chain_id = " + query.bind(chainID))
+		query.where("block_number >= " + query.bind(blockNumber))
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM balance_snapshots`+query.whereClause(), query.args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyBalanceChanges updates the balances within tx. Each balance is created
+// if missing and locked before it is read, and removed when it drops to zero.
+func (s *sqlStore) applyBalanceChanges(ctx context.Context, tx *sql.Tx, changes []*balanceChange) error {
+	for _, change := range changes {
+		key := &sqlQuery{dialect: s.dialect}
+		key.whereBalance(change.key)
+
+		insert := &sqlQuery{dialect: s.dialect}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO balances (chain_id, contract_address, standard, token_id, account, balance, balance_key)
+			VALUES (%s, %s, %s, %s, %s, %s, %s) ON CONFLICT DO NOTHING`,
+			insert.bind(change.key.chainID), insert.bind(change.key.contract.Hex()), insert.bind(change.standard),
+			insert.bind(change.key.tokenID), insert.bind(change.key.account.Hex()), insert.bind("0"), insert.bind(BalanceKey(nil))),
+			insert.args...)
+		if err != nil {
+			return err
+		}
+
+		var current string
+		err = tx.QueryRowContext(ctx, `SELECT balance FROM balances`+key.whereClause()+s.dialect.forUpdate, key.args...).Scan(&current)
+		if err != nil {
+			return err
+		}
+
+		balance, ok := new(big.Int).SetString(current, 10)
+		if !ok {
+			return fmt.Errorf("invalid balance %q", current)
+		}
+		balance.Add(balance, change.delta)
+
+		if balance.Sign() == 0 {
+			_, err = tx.ExecContext(ctx, `DELETE FROM balances`+key.whereClause(), key.args...)
+		} else {
+			update := &sqlQuery{dialect: s.dialect}
+			set := fmt.Sprintf(`UPDATE balances SET balance = %s, balance_key = %s`, update.bind(balance.String()), update.bind(BalanceKey(balance)))
+			update.whereBalance(change.key)
+			_, err = tx.ExecContext(ctx, set+update.whereClause(), update.args...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildBalances adds up within tx the balances of the transfers stored
+// before balances were maintained.
+func (s *sqlStore) rebuildBalances(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT `+transferColumns+` FROM transfers`)
+	if err != nil {
+		return err
+	}
+
+	changes := make(map[balanceKey]*balanceChange)
+	for rows.Next() {
+		transfer, err := scanTransfer(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		addBalanceChanges(changes, []*TransferLog{transfer}, false)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return s.applyBalanceChanges(ctx, tx, sortBalanceChanges(changes))
+}
+
+func (s *sqlStore) GetBalanceSnapshot(ctx context.Context, chainID uint64, account common.Address, blockNumber uint64) (*BalanceSnapshot, error) {
+	query := &sqlQuery{dialect: s.dialect}
+	query.where("chain_id = " + query.bind(chainID))
+	query.where("account = " + query.bind(account.Hex()))
+	query.where("block_number <= " + query.bind(blockNumber))
+
+	var balances string
+	snapshot := &BalanceSnapshot{ChainID: chainID, Account: account}
+	err := s.db.QueryRowContext(ctx, `SELECT block_number, balances FROM balance_snapshots`+query.whereClause()+` ORDER BY block_number DESC LIMIT 1`, query.args...).
+		Scan(&snapshot.BlockNumber, &balances)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(balances), &snapshot.Balances); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+func (s *sqlStore) SaveBalanceSnapshot(ctx context.Context, snapshot *BalanceSnapshot) error {
+	balances, err := json.Marshal(snapshot.Balances)
+	if err != nil {
+		return err
+	}
+
+	query := &sqlQuery{dialect: s.dialect}
+	statement := fmt.Sprintf(`INSERT INTO balance_snapshots (chain_id, account, block_number, balances) VALUES (%s, %s, %s, %s)
+		ON CONFLICT (chain_id, account, block_number) DO UPDATE SET balances = excluded.balances`,
+		query.bind(snapshot.ChainID), query.bind(snapshot.Account.Hex()), query.bind(snapshot.BlockNumber), query.bind(string(balances)))
+
+	_, err = s.db.ExecContext(ctx, statement, query.args...)
+	return err
+}
+
+func (s *sqlStore) balanceQuery(filter BalanceFilter) *sqlQuery {
+	query := &sqlQuery{dialect: s.dialect}
+	if filter.ChainID != nil {
+		query.where("chain_id = " + query.bind(*filter.ChainID))
+	}
+	if filter.Account != nil {
+		query.where("account = " + query.bind(filter.Account.Hex()))
+	}
+	if filter.Contract != nil {
+		query.where("contract_address = " + query.bind(filter.Contract.Hex()))
+	}
+	if filter.TokenID != nil {
+		query.where("token_id = " + query.bind(filter.TokenID.String()))
+	}
+	if filter.Standard != "" {
+		query.where("standard = " + query.bind(filter.Standard))
+	}
+	if filter.Positive {
+		query.where("balance_key >= " + query.bind(ValueKey(big.NewInt(1))))
+	}
+	return query
+}
+
+func (s *sqlStore) CountBalances(ctx context.Context, filter BalanceFilter) (int64, error) {
+	query := s.balanceQuery(filter)
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM balances`+query.whereClause(), query.args...).Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) CountHolders(ctx context.Context, filter BalanceFilter) (int64, error) {
+	filter.Positive = true
+	query := s.balanceQuery(filter)
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT account) FROM balances`+query.whereClause(), query.args...).Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) FindBalances(ctx context.Context, filter BalanceFilter, offset int64, limit int64) ([]*Balance, error) {
+	query := s.balanceQuery(filter)
+
+	direction := "DESC"
+	if filter.Ascending {
+		direction = "ASC"
+	}
+
+	statement := fmt.Sprintf(`SELECT %s FROM balances%s
+		ORDER BY balance_key %s, contract_address, token_id, account LIMIT %s OFFSET %s`,
+		balanceColumns, query.whereClause(), direction, query.bind(limit), query.bind(offset))
+
+	return s.queryBalances(ctx, statement, query.args)
+}
+
+// Both PostgreSQL and SQLite implement RANDOM(). Sorting by it reads every
+// matching balance, but in a single query.
+func (s *sqlStore) SampleBalances(ctx context.Context, filter BalanceFilter, size int64) ([]*Balance, error) {
+	query := s.balanceQuery(filter)
+
+	statement := fmt.Sprintf(`SELECT %s FROM balances%s ORDER BY RANDOM() LIMIT %s`,
+		balanceColumns, query.whereClause(), query.bind(size))
+
+	return s.queryBalances(ctx, statement, query.args)
+}
+
+const balanceColumns = `chain_id, contract_address, standard, token_id, account, balance`
+
+func (s *sqlStore) queryBalances(ctx context.Context, statement string, args []interface{}) ([]*Balance, error) {
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []*Balance{}
+	for rows.Next() {
+		var contract, tokenID, account, value string
+
+		balance := &Balance{}
+		if err := rows.Scan(&balance.ChainID, &contract, &balance.Standard, &tokenID, &account, &value); err != nil {
+			return nil, err
+		}
+
+		balance.ContractAddress = common.HexToAddress(contract)
+		balance.TokenID = parseTokenID(tokenID)
+		balance.Account = common.HexToAddress(account)
+
+		var ok bool
+		balance.Balance, ok = new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid balance %q", value)
+		}
+
+		balances = append(balances, balance)
+	}
+
+	return balances, rows.Err()
+}
+
+func (s *sqlStore) ConfirmTransfers(ctx context.Context, chainID uint64, upToBlock uint64) (int64, error) {
+	query := &sqlQuery{dialect: s.dialect}
+	status := query.bind(TransferStatusConfirmed)
+	query.where("chain_id = " + query.bind(chainID))
+	query.where("status = " + query.bind(TransferStatusPending))
+	query.where("block_number <= " + query.bind(upToBlock))
+
+	return s.exec(ctx, `UPDATE transfers SET status = `+status+query.whereClause(), query.args...)
+}
+
+func (s *sqlStore) exec(ctx context.Context, statement string, args ...interface{}) (int64, error) {
+	result, err := s.db.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const tokenColumns = `chain_id, address, name, symbol, decimals, updated_at`
+
+func scanToken(row interface{ Scan(...interface{}) error }) (*Token, error) {
+	var address string
+	var decimals sql.NullInt64
+
+	token := &Token{}
+	err := row.Scan(&token.ChainID, &address, &token.Name, &token.Symbol, &decimals, &token.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Address = common.HexToAddress(address)
+	if decimals.Valid {
+		value := uint8(decimals.Int64)
+		token.Decimals = &value
+	}
+
+	return token, nil
+}
+
+func (s *sqlStore) GetToken(ctx context.Context, chainID uint64, address common.Address) (*Token, error) {
+	query := &sqlQuery{dialect: s.dialect}
+	query.where("chain_id = " + query.bind(chainID))
+	query.where("address = " + query.bind(address.Hex()))
+
+	token, err := scanToken(s.db.QueryRowContext(ctx, `SELECT `+tokenColumns+` FROM tokens`+query.whereClause(), query.args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *sqlStore) SaveToken(ctx context.Context, token *Token) error {
+	var decimals sql.NullInt64
+	if token.Decimals != nil {
+		decimals = sql.NullInt64{Int64: int64(*token.Decimals), Valid: true}
+	}
+
+	query := &sqlQuery{dialect: s.dialect}
+	statement := fmt.Sprintf(`INSERT INTO tokens (%s) VALUES (%s, %s, %s, %s, %s, %s)
+		ON CONFLICT (chain_id, address) DO UPDATE SET name = excluded.name, symbol = excluded.symbol,
+		decimals = excluded.decimals, updated_at = excluded.updated_at`, tokenColumns,
+		query.bind(token.ChainID), query.bind(token.Address.Hex()), query.bind(token.Name), query.bind(token.Symbol),
+		query.bind(decimals), query.bind(token.UpdatedAt))
+
+	_, err := s.db.ExecContext(ctx, statement, query.args...)
+	return err
+}
+
+func (s *sqlStore) FindTokens(ctx context.Context, chainID *uint64, offset int64, limit int64) ([]*Token, error) {
+	query := &sqlQuery{dialect: s.dialect}
+	if chainID != nil {
+		query.where("chain_id = " + query.bind(*chainID))
+	}
+
+	statement := fmt.Sprintf(`SELECT %s FROM tokens%s ORDER BY chain_id, address LIMIT %s OFFSET %s`,
+		tokenColumns, query.whereClause(), query.bind(limit), query.bind(offset))
+
+	rows, err := s.db.QueryContext(ctx, statement, query.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s *sqlStore) SaveDiscrepancy(ctx context.Context, discrepancy *Discrepancy) error {
+	query := &sqlQuery{dialect: s.dialect}
+	statement := fmt.Sprintf(`INSERT INTO discrepancies (chain_id, kind, contract_address, account, block_number, indexed_value, on_chain_value, detected_at)
+		VALUES (%s, %s, %s, %s, %s, %s, %s, %s)
+		ON CONFLICT (chain_id, kind, contract_address, account, block_number) DO UPDATE SET
+		indexed_value = excluded.indexed_value, on_chain_value = excluded.on_chain_value, detected_at = excluded.detected_at`,
+		query.bind(discrepancy.ChainID), query.bind(discrepancy.Kind), query.bind(discrepancy.ContractAddress.Hex()),
+		query.bind(discrepancy.Account.Hex()), query.bind(discrepancy.BlockNumber), query.bind(bigIntString(discrepancy.Indexed)),
+		query.bind(bigIntString(discrepancy.OnChain)), query.bind(discrepancy.DetectedAt))
+
+	_, err := s.db.ExecContext(ctx, statement, query.args...)
+	return err
+}
+
+func (s *sqlStore) FindDiscrepancies(ctx context.Context, chainID *uint64, offset int64, limit int64) ([]*Discrepancy, error) {
+	query := &sqlQuery{dialect: s.dialect}
+	if chainID != nil {
+		query.where("chain_id = " + query.bind(*chainID))
+	}
+
+	statement := fmt.Sprintf(`SELECT chain_id, kind, contract_address, account, block_number, indexed_value, on_chain_value, detected_at
+		FROM discrepancies%s ORDER BY detected_at DESC, block_number DESC LIMIT %s OFFSET %s`,
+		query.whereClause(), query.bind(limit), query.bind(offset))
+
+	rows, err := s.db.QueryContext(ctx, statement, query.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discrepancies := []*Discrepancy{}
+	for rows.Next() {
+		var contract, account, indexed, onChain string
+
+		discrepancy := &Discrepancy{}
+		err := rows.Scan(&discrepancy.ChainID, &discrepancy.Kind, &contract, &account, &discrepancy.BlockNumber, &indexed, &onChain, &discrepancy.DetectedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		discrepancy.ContractAddress = common.HexToAddress(contract)
+		discrepancy.Account = common.HexToAddress(account)
+		discrepancy.Indexed, _ = new(big.Int).SetString(indexed, 10)
+		discrepancy.OnChain, _ = new(big.Int).SetString(onChain, 10)
+
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	return discrepancies, rows.Err()
+}
+
+func (s *sqlStore) GetCheckpoint(ctx context.Context, chainID uint64, collection string) (*Checkpoint, error) {
+	query := &sqlQuery{dialect: s.dialect}
+	query.where("chain_id = " + query.bind(chainID))
+	query.where("collection = " + query.bind(collection))
+
+	checkpoint := &Checkpoint{}
+	err := s.db.QueryRowContext(ctx, `SELECT chain_id, collection, block_number, updated_at FROM checkpoints`+query.whereClause(), query.args...).
+		Scan(&checkpoint.ChainID, &checkpoint.Collection, &checkpoint.BlockNumber, &checkpoint.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+func (s *sqlStore) SaveCheckpoint(ctx context.Context, chainID uint64, collection string, blockNumber uint64) error {
+	return s.upsertCheckpoint(ctx, chainID, collection, blockNumber,
+		fmt.Sprintf("%s(checkpoints.block_number, excluded.block_number)", s.dialect.greatest))
+}
+
+func (s *sqlStore) ResetCheckpoint(ctx context.Context, chainID uint64, collection string, blockNumber uint64) error {
+	return s.upsertCheckpoint(ctx, chainID, collection, blockNumber, "excluded.block_number")
+}
+
+func (s *sqlStore) upsertCheckpoint(ctx context.Context, chainID uint64, collection string, blockNumber uint64, blockNumberUpdate string) error {
+	query := &sqlQuery{dialect: s.dialect}
+	statement := fmt.Sprintf(`INSERT INTO checkpoints (chain_id, collection, block_number, updated_at) VALUES (%s, %s, %s, %s)
+		ON CONFLICT (chain_id, collection) DO UPDATE SET block_number = %s, updated_at = excluded.updated_at`,
+		query.bind(chainID), query.bind(collection), query.bind(blockNumber), query.bind(time.Now().Unix()), blockNumberUpdate)
+
+	_, err := s.db.ExecContext(ctx, statement, query.args...)
+	return err
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+var sqliteDialect = sqlDialect{
+	placeholder: func(n int) string { return "?" },
+	greatest:    "MAX",
+	// The single connection already serializes transactions.
+	forUpdate: "",
+	// 0005 creates the balances and 0008 empties them to recompute their keys.
+	balanceRebuilds: map[int]bool{5: true, 8: true},
+}
+
+func openSQLite(path string) (Store, error) {
+	if path == "" {
+		return nil, errors.New("sqlitePath is required for the sqlite storage")
+	}
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path))
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, so the ingestion workers share one
+	// connection instead of failing with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+
+	migrations, err := fs.Sub(sqliteMigrations, "migrations/sqlite")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlStore{db: db, dialect: sqliteDialect, migrations: migrations}, nil
+}
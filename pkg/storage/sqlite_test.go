@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	testToken = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	alice     = common.HexToAddress("0x0000000000000000000000000000000000000001")
+	bob       = common.HexToAddress("0x0000000000000000000000000000000000000002")
+	carol     = common.HexToAddress("0x0000000000000000000000000000000000000003")
+)
+
+func openTestSQLite(t *testing.T) *sqlStore {
+	t.Helper()
+
+	store, err := openSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open SQLite store: %v", err)
+	}
+	t.Cleanup(func() { store.(*sqlStore).db.Close() })
+
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate SQLite store: %v", err)
+	}
+	return store.(*sqlStore)
+}
+
+func testTransfer(blockNumber uint64, index uint, from common.Address, to common.Address, value int64) *TransferLog {
+	return &TransferLog{
+		ChainID:         1,
+		From:            from,
+		To:              to,
+		Value:           big.NewInt(value),
+		ContractAddress: testToken,
+		BlockNumber:     blockNumber,
+		BlockHash:       common.BigToHash(new(big.Int).SetUint64(blockNumber)),
+		TxHash:          common.BigToHash(new(big.Int).SetUint64(blockNumber*1000 + uint64(index))),
+		Index:           index,
+		BlockTimeStamp:  1600000000 + blockNumber*12,
+		Status:          TransferStatusConfirmed,
+		Standard:        TokenStandardERC20,
+	}
+}
+
+func TestSQLiteInsertTransfers(t *testing.T) {
+	ctx := context.Background()
+	store := openTestSQLite(t)
+
+	transfers := []*TransferLog{
+		testTransfer(10, 0, common.Address{}, alice, 1000),
+		testTransfer(11, 0, alice, bob, 300),
+		testTransfer(12, 0, bob, carol, 100),
+	}
+
+	inserted, err := store.InsertTransfers(ctx, transfers)
+	if err != nil {
+		t.Fatalf("InsertTransfers: %v", err)
+	}
+	if len(inserted) != len(transfers) {
+		t.Fatalf("inserted %v transfers, want %v", len(inserted), len(transfers))
+	}
+
+	// Transfers stored before are skipped, so their balance changes are not
+	// applied twice.
+	inserted, err = store.InsertTransfers(ctx, append(transfers, testTransfer(13, 0, carol, alice, 50)))
+	if err != nil {
+		t.Fatalf("InsertTransfers again: %v", err)
+	}
+	if len(inserted) != 1 || inserted[0].BlockNumber != 13 {
+		t.Fatalf("inserted %v transfers again, want only block 13", len(inserted))
+	}
+
+	count, err := store.CountTransfers(ctx, TransferFilter{})
+	if err != nil {
+		t.Fatalf("CountTransfers: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("stored %v transfers, want 4", count)
+	}
+
+	want := map[common.Address]int64{alice: 750, bob: 200, carol: 50}
+	balances, err := store.FindBalances(ctx, BalanceFilter{Contract: &testToken}, 0, 10)
+	if err != nil {
+		t.Fatalf("FindBalances: %v", err)
+	}
+	if len(balances) != len(want) {
+		t.Fatalf("got %v balances, want %v", len(balances), len(want))
+	}
+	for i, balance := range balances {
+		if balance.Balance.Int64() != want[balance.Account] {
+			t.Errorf("balance of %v is %v, want %v", balance.Account.Hex(), balance.Balance, want[balance.Account])
+		}
+		if i > 0 && balance.Balance.Cmp(balances[i-1].Balance) > 0 {
+			t.Errorf("balances are not ordered from the largest")
+		}
+	}
+
+	count, err = store.CountBalances(ctx, BalanceFilter{Contract: &testToken})
+	if err != nil {
+		t.Fatalf("CountBalances: %v", err)
+	}
+	if count != int64(len(want)) {
+		t.Errorf("counted %v balances, want %v", count, len(want))
+	}
+}
+
+func TestSQLiteFindTransfers(t *testing.T) {
+	ctx := context.Background()
+	store := openTestSQLite(t)
+
+	_, err := store.InsertTransfers(ctx, []*TransferLog{
+		testTransfer(10, 0, common.Address{}, alice, 1000),
+		testTransfer(11, 0, alice, bob, 300),
+		testTransfer(11, 1, alice, carol, 200),
+		testTransfer(12, 0, bob, carol, 100),
+	})
+	if err != nil {
+		t.Fatalf("InsertTransfers: %v", err)
+	}
+
+	otherToken := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	fromBlock, toBlock := uint64(11), uint64(11)
+
+	tests := []struct {
+		name       string
+		filter     TransferFilter
+		offset     int64
+		limit      int64
+		wantBlocks []uint64
+		wantCount  int64
+	}{
+		{
+			name:       "all",
+			limit:      100,
+			wantBlocks: []uint64{10, 11, 11, 12},
+			wantCount:  4,
+		},
+		{
+			// GetAddresses matches the address as sender or recipient.
+			name:       "address",
+			filter:     TransferFilter{Address: &bob},
+			limit:      100,
+			wantBlocks: []uint64{11, 12},
+			wantCount:  2,
+		},
+		{
+			name:       "contract address",
+			filter:     TransferFilter{Address: &testToken},
+			limit:      100,
+			wantBlocks: []uint64{10, 11, 11, 12},
+			wantCount:  4,
+		},
+		{
+			name:   "other contract",
+			filter: TransferFilter{Contract: &otherToken},
+			limit:  100,
+		},
+		{
+			name:       "block range",
+			filter:     TransferFilter{FromBlock: &fromBlock, ToBlock: &toBlock},
+			limit:      100,
+			wantBlocks: []uint64{11, 11},
+			wantCount:  2,
+		},
+		{
+			name:       "page",
+			offset:     1,
+			limit:      2,
+			wantBlocks: []uint64{11, 11},
+			wantCount:  4,
+		},
+		{
+			name:       "value",
+			filter:     TransferFilter{Sort: TransferSortValue},
+			limit:      2,
+			wantBlocks: []uint64{10, 11},
+			wantCount:  4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transfers, err := store.FindTransfers(ctx, test.filter, test.offset, test.limit)
+			if err != nil {
+				t.Fatalf("FindTransfers: %v", err)
+			}
+			var blocks []uint64
+			for _, transfer := range transfers {
+				blocks = append(blocks, transfer.BlockNumber)
+			}
+			if !equalBlocks(blocks, test.wantBlocks) {
+				t.Errorf("got blocks %v, want %v", blocks, test.wantBlocks)
+			}
+
+			count, err := store.CountTransfers(ctx, test.filter)
+			if err != nil {
+				t.Fatalf("CountTransfers: %v", err)
+			}
+			if count != test.wantCount {
+				t.Errorf("counted %v transfers, want %v", count, test.wantCount)
+			}
+		})
+	}
+}
+
+func equalBlocks(got []uint64, want []uint64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSQLiteCountHolders(t *testing.T) {
+	ctx := context.Background()
+	store := openTestSQLite(t)
+
+	_, err := store.InsertTransfers(ctx, []*TransferLog{
+		testTokenTransfer(10, common.Address{}, alice, 1, 5),
+		testTokenTransfer(11, common.Address{}, alice, 2, 5),
+		testTokenTransfer(12, common.Address{}, bob, 1, 1),
+		// Bob sends the only token they hold to Carol.
+		testTokenTransfer(13, bob, carol, 1, 1),
+	})
+	if err != nil {
+		t.Fatalf("InsertTransfers: %v", err)
+	}
+
+	filter := BalanceFilter{Contract: &testToken}
+
+	holders, err := store.CountHolders(ctx, filter)
+	if err != nil {
+		t.Fatalf("CountHolders: %v", err)
+	}
+	if holders != 2 {
+		t.Errorf("counted %v holders, want 2", holders)
+	}
+
+	balances, err := store.CountBalances(ctx, BalanceFilter{Contract: &testToken, Positive: true})
+	if err != nil {
+		t.Fatalf("CountBalances: %v", err)
+	}
+	if balances != 3 {
+		t.Errorf("counted %v positive balances, want 3", balances)
+	}
+}
+
+func testTokenTransfer(blockNumber uint64, from common.Address, to common.Address, tokenID int64, value int64) *TransferLog {
+	transfer := testTransfer(blockNumber, 0, from, to, value)
+	transfer.Standard = TokenStandardERC1155
+	transfer.TokenID = big.NewInt(tokenID)
+	return transfer
+}
+
+func TestSQLiteInsertTransfersDeletesStaleSnapshots(t *testing.T) {
+	ctx := context.Background()
+	store := openTestSQLite(t)
+
+	err := store.SaveBalanceSnapshot(ctx, &BalanceSnapshot{ChainID: 1, Account: alice, BlockNumber: 100})
+	if err != nil {
+		t.Fatalf("SaveBalanceSnapshot: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		blockNumber  uint64
+		wantSnapshot bool
+	}{
+		{name: "after the snapshot", blockNumber: 200, wantSnapshot: true},
+		{name: "before the snapshot", blockNumber: 50, wantSnapshot: false},
+	}
+
+	for _, test := range tests {
+		_, err := store.InsertTransfers(ctx, []*TransferLog{testTransfer(test.blockNumber, 0, common.Address{}, alice, 10)})
+		if err != nil {
+			t.Fatalf("%s: InsertTransfers: %v", test.name, err)
+		}
+
+		snapshot, err := store.GetBalanceSnapshot(ctx, 1, alice, 1000)
+		if err != nil {
+			t.Fatalf("%s: GetBalanceSnapshot: %v", test.name, err)
+		}
+		if got := snapshot != nil; got != test.wantSnapshot {
+			t.Errorf("%s: snapshot kept %v, want %v", test.name, got, test.wantSnapshot)
+		}
+	}
+}
+
+func TestSQLiteMigrateRebuildsBalances(t *testing.T) {
+	ctx := context.Background()
+	store := openTestSQLite(t)
+
+	_, err := store.InsertTransfers(ctx, []*TransferLog{
+		testTransfer(10, 0, common.Address{}, alice, 1000),
+		testTransfer(11, 0, alice, bob, 300),
+	})
+	if err != nil {
+		t.Fatalf("InsertTransfers: %v", err)
+	}
+
+	// Migrating again rebuilds nothing, even with the balances gone.
+	if _, err := store.db.ExecContext(ctx, `DELETE FROM balances`); err != nil {
+		t.Fatalf("failed to delete balances: %v", err)
+	}
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	count, err := store.CountBalances(ctx, BalanceFilter{})
+	if err != nil {
+		t.Fatalf("CountBalances: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("counted %v balances after migrating again, want 0", count)
+	}
+
+	// Migration 0008 empties the balances and rebuilds them.
+	if _, err := store.db.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = 8`); err != nil {
+		t.Fatalf("failed to forget migration 0008: %v", err)
+	}
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	want := map[common.Address]int64{alice: 700, bob: 300}
+	balances, err := store.FindBalances(ctx, BalanceFilter{Contract: &testToken}, 0, 10)
+	if err != nil {
+		t.Fatalf("FindBalances: %v", err)
+	}
+	if len(balances) != len(want) {
+		t.Fatalf("got %v balances, want %v", len(balances), len(want))
+	}
+	for _, balance := range balances {
+		if balance.Balance.Int64() != want[balance.Account] {
+			t.Errorf("balance of %v is %v, want %v", balance.Account.Hex(), balance.Balance, want[balance.Account])
+		}
+	}
+}
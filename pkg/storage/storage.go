@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/database"
+)
+
+const (
+	TransferStatusPending   = "pending"
+	TransferStatusConfirmed = "confirmed"
+)
+
+const (
+	TokenStandardERC20   = "erc20"
+	TokenStandardERC721  = "erc721"
+	TokenStandardERC1155 = "erc1155"
+)
+
+const (
+	TransferSortBlock = "block"
+	TransferSortValue = "value"
+)
+
+// valueDigits is the number of decimal digits of the largest uint256.
+const valueDigits = 78
+
+type TransferLog struct {
+	ChainID         uint64         `json:"chainId"`
+	From            common.Address `json:"from"`
+	To              common.Address `json:"to"`
+	Value           *big.Int       `json:"value"`
+	ContractAddress common.Address `json:"contractAddress"`
+	BlockNumber     uint64         `json:"blockNumber"`
+	BlockHash       common.Hash    `json:"blockHash"`
+	TxHash          common.Hash    `json:"txHash"`
+	TxIndex         uint           `json:"txIndex"`
+	Index           uint           `json:"index"`
+	BlockTimeStamp  uint64         `json:"blockTimeStamp"`
+	Status          string         `json:"status"`
+	Standard        string         `json:"standard"`
+	TokenID         *big.Int       `json:"tokenId,omitempty"`
+	Operator        common.Address `json:"operator"`
+	BatchIndex      uint           `json:"batchIndex"`
+}
+
+// Token is the metadata of a token contract. Fields the contract does not
+// implement are left empty.
+type Token struct {
+	ChainID   uint64         `json:"chainId"`
+	Address   common.Address `json:"address"`
+	Name      string         `json:"name"`
+	Symbol    string         `json:"symbol"`
+	Decimals  *uint8         `json:"decimals"`
+	UpdatedAt int64          `json:"updatedAt"`
+}
+
+const (
+	DiscrepancyBalance = "balance"
+	DiscrepancySupply  = "supply"
+)
+
+// Discrepancy is an indexed balance or total supply that differs from what the
+// token contract reports at the same block. Supply discrepancies have no
+// account.
+type Discrepancy struct {
+	ChainID         uint64         `json:"chainId"`
+	Kind            string         `json:"kind"`
+	ContractAddress common.Address `json:"contractAddress"`
+	Account         common.Address `json:"account"`
+	BlockNumber     uint64         `json:"blockNumber"`
+	Indexed         *big.Int       `json:"indexed"`
+	OnChain         *big.Int       `json:"onChain"`
+	DetectedAt      int64          `json:"detectedAt"`
+}
+
+type Checkpoint struct {
+	ChainID     uint64 `json:"chainId"`
+	Collection  string `json:"collection"`
+	BlockNumber uint64 `json:"blockNumber"`
+	UpdatedAt   int64  `json:"updatedAt"`
+}
+
+// TransferFilter selects transfers. Nil fields and empty strings match every
+// transfer.
+type TransferFilter struct {
+	ChainID *uint64
+	// Address matches the sender, the recipient or the contract.
+	Address   *common.Address
+	Contract  *common.Address
+	FromBlock *uint64
+	ToBlock   *uint64
+	FromTime  *uint64
+	ToTime    *uint64
+	MinValue  *big.Int
+	MaxValue  *big.Int
+	Status    string
+	Standard  string
+	// Sort orders transfers by block, the default, or by value, largest
+	// first.
+	Sort string
+}
+
+// ValueKey zero-pads value to a fixed width, so that keys compare as strings
+// in the same order as the values compare as numbers.
+func ValueKey(value *big.Int) string {
+	digits := bigIntString(value)
+	if len(digits) >= valueDigits {
+		return digits
+	}
+	return strings.Repeat("0", valueDigits-len(digits)) + digits
+}
+
+// Store keeps transfers, the balances they add up to, token metadata and sync
+// checkpoints.
+type Store interface {
+	// Migrate prepares the schema and indexes the store relies on.
+	Migrate(ctx context.Context) error
+
+	// InsertTransfers skips transfers stored before and returns the ones it
+	// inserted. Balances are updated with the inserted transfers, and
+	// reversed when transfers are removed.
+	InsertTransfers(ctx context.Context, transfers []*TransferLog) ([]*TransferLog, error)
+	FindTransfers(ctx context.Context, filter TransferFilter, offset int64, limit int64) ([]*TransferLog, error)
+	CountTransfers(ctx context.Context, filter TransferFilter) (int64, error)
+	RemoveTransfers(ctx context.Context, chainID uint64, txHash common.Hash, index uint, blockHash common.Hash) (int64, error)
+	// RemoveOrphanedTransfers removes the transfers of blockNumber that are not
+	// part of the block with canonicalHash.
+	RemoveOrphanedTransfers(ctx context.Context, chainID uint64, blockNumber uint64, canonicalHash common.Hash) (int64, error)
+	ConfirmTransfers(ctx context.Context, chainID uint64, upToBlock uint64) (int64, error)
+	// TransferVolume groups the transfers matching filter by the time their
+	// block was mined, in buckets of interval seconds, oldest first.
+	TransferVolume(ctx context.Context, filter TransferFilter, interval uint64) ([]*VolumeBucket, error)
+
+	// FindBalances returns balances ordered from the largest, unless the
+	// filter asks for the smallest first.
+	FindBalances(ctx context.Context, filter BalanceFilter, offset int64, limit int64) ([]*Balance, error)
+	CountBalances(ctx context.Context, filter BalanceFilter) (int64, error)
+	// CountHolders counts the distinct accounts with a positive balance
+	// matching filter, however many token IDs they hold.
+	CountHolders(ctx context.Context, filter BalanceFilter) (int64, error)
+	// SampleBalances returns up to size balances picked at random.
+	SampleBalances(ctx context.Context, filter BalanceFilter, size int64) ([]*Balance, error)
+	// GetBalanceSnapshot returns the latest snapshot of account taken at or
+	// before blockNumber, or nil. Snapshots after a block whose transfers are
+	// removed are dropped.
+	GetBalanceSnapshot(ctx context.Context, chainID uint64, account common.Address, blockNumber uint64) (*BalanceSnapshot, error)
+	SaveBalanceSnapshot(ctx context.Context, snapshot *BalanceSnapshot) error
+
+	// GetToken returns nil when the token is not stored.
+	GetToken(ctx context.Context, chainID uint64, address common.Address) (*Token, error)
+	SaveToken(ctx context.Context, token *Token) error
+	FindTokens(ctx context.Context, chainID *uint64, offset int64, limit int64) ([]*Token, error)
+
+	// SaveDiscrepancy replaces the discrepancy found before for the same
+	// balance or supply at the same block.
+	SaveDiscrepancy(ctx context.Context, discrepancy *Discrepancy) error
+	// FindDiscrepancies returns the latest discrepancies first.
+	FindDiscrepancies(ctx context.Context, chainID *uint64, offset int64, limit int64) ([]*Discrepancy, error)
+
+	GetCheckpoint(ctx context.Context, chainID uint64, collection string) (*Checkpoint, error)
+	// SaveCheckpoint only ever moves the checkpoint forward.
+	SaveCheckpoint(ctx context.Context, chainID uint64, collection string, blockNumber uint64) error
+	ResetCheckpoint(ctx context.Context, chainID uint64, collection string, blockNumber uint64) error
+}
+
+var store Store
+
+// Open connects the backend selected by configs.Storage and migrates it.
+func Open() error {
+	var err error
+
+	switch configs.Storage {
+	case "mongo":
+		if !database.Enabled() {
+			return errors.New("mongoEndpoint is required for the mongo storage")
+		}
+		store = &mongoStore{}
+	case "postgres":
+		store, err = openPostgres(configs.PostgresDSN)
+	case "sqlite":
+		store, err = openSQLite(configs.SQLitePath)
+	default:
+		return fmt.Errorf("unknown storage backend %q", configs.Storage)
+	}
+	if err != nil {
+		return err
+	}
+
+	return store.Migrate(context.Background())
+}
+
+func GetStore() (Store, error) {
+	if store == nil {
+		return nil, fmt.Errorf("storage is not opened")
+	}
+	return store, nil
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"math/big"
+)
+
+const (
+	VolumeIntervalHour uint64 = 3600
+	VolumeIntervalDay  uint64 = 86400
+)
+
+// VolumeBucket aggregates the transfers whose block was mined within
+// [Time, Time+interval).
+type VolumeBucket struct {
+	Time      uint64   `json:"time"`
+	Transfers int64    `json:"transfers"`
+	Value     *big.Int `json:"value"`
+	Senders   int64    `json:"senders"`
+	Receivers int64    `json:"receivers"`
+}
+
+// Databases cannot sum 256-bit values exactly, so the value keys are cut into
+// parts small enough to be summed as integers, and the part sums joined back.
+func joinValueKeyParts(parts []*big.Int, digits int) *big.Int {
+	base := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+
+	value := new(big.Int)
+	for _, part := range parts {
+		value.Mul(value, base)
+		value.Add(value, part)
+	}
+	return value
+}
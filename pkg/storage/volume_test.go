@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestJoinValueKeyParts(t *testing.T) {
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+	tests := []struct {
+		name   string
+		digits int
+		values []*big.Int
+	}{
+		{name: "no transfers", digits: sqlValuePartDigits},
+		{name: "single value", digits: sqlValuePartDigits, values: []*big.Int{big.NewInt(1500000)}},
+		{name: "carries across parts", digits: sqlValuePartDigits, values: []*big.Int{big.NewInt(999999), big.NewInt(999999), big.NewInt(2)}},
+		{name: "largest values", digits: sqlValuePartDigits, values: []*big.Int{maxUint256, maxUint256, maxUint256}},
+		{name: "largest values in MongoDB parts", digits: mongoValuePartDigits, values: []*big.Int{maxUint256, maxUint256, big.NewInt(1)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Sum each part of the value keys the way the databases do.
+			parts := make([]*big.Int, valueDigits/test.digits)
+			for i := range parts {
+				parts[i] = new(big.Int)
+			}
+			want := new(big.Int)
+			for _, value := range test.values {
+				key := ValueKey(value)
+				for i := range parts {
+					part, ok := new(big.Int).SetString(key[i*test.digits:(i+1)*test.digits], 10)
+					if !ok {
+						t.Fatalf("invalid value key %q", key)
+					}
+					parts[i].Add(parts[i], part)
+				}
+				want.Add(want, value)
+			}
+
+			if got := joinValueKeyParts(parts, test.digits); got.Cmp(want) != 0 {
+				t.Errorf("joined %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -3,41 +3,139 @@ package subscriber
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 
+	"github.com/junwei0117/logs-collector/pkg/collectors"
 	"github.com/junwei0117/logs-collector/pkg/configs"
 	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
 )
 
-var (
-	Erc20TransferSig = []byte("Transfer(address,address,uint256)")
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
 )
 
-func SubscribeToTransferLogs() (<-chan types.Log, error) {
-	client, err := ethclient.DialContext(context.Background(), configs.WebsocketRPCEndpoint)
-	if err != nil {
-		logger.Logger.Errorf("Failed to connect to Ethereum client: %v", err)
+// SubscribeToTransferLogs returns a channel that keeps delivering transfer logs
+// across websocket drops. Once subscribed, the blocks since fromBlock are
+// backfilled into the same channel, and so are the blocks missed while
+// disconnected after every reconnect.
+func SubscribeToTransferLogs(chain configs.Chain, fromBlock uint64) <-chan types.Log {
+	logs := make(chan types.Log)
+	go superviseSubscription(chain, fromBlock, logs)
+	return logs
+}
+
+func superviseSubscription(chain configs.Chain, fromBlock uint64, logs chan<- types.Log) {
+	filter := ethereum.FilterQuery{
+		Addresses: collectors.FilterAddresses(),
+		Topics:    [][]common.Hash{collectors.EventTopics()},
 	}
 
-	topic := crypto.Keccak256Hash(Erc20TransferSig)
+	lastBlock := fromBlock
+	delay := minReconnectDelay
 
-	filter := ethereum.FilterQuery{
-		Topics: [][]common.Hash{{topic}},
+	for {
+		endpoint, client, sub, subLogs, err := subscribe(chain, filter)
+		if err != nil {
+			logger.Logger.Warnf("[Subscriber] [%s] Failed to subscribe to events: %v. retrying in %v...", chain.Name, err, delay)
+			time.Sleep(delay)
+			delay = nextReconnectDelay(delay)
+			continue
+		}
+
+		lastBlock, err = backfillGap(chain, lastBlock, logs)
+		if err != nil {
+			logger.Logger.Warnf("[Subscriber] [%s] Failed to backfill missed events: %v. retrying in %v...", chain.Name, err, delay)
+			sub.Unsubscribe()
+			client.Close()
+			time.Sleep(delay)
+			delay = nextReconnectDelay(delay)
+			continue
+		}
+
+		delay = minReconnectDelay
+		lastBlock, err = forwardLogs(chain, sub, subLogs, logs, lastBlock)
+		endpoint.Report(0, err)
+
+		sub.Unsubscribe()
+		client.Close()
 	}
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// subscribe dials its own connection to the healthiest websocket endpoint of
+// the chain. A dropped subscription is reported against it, so the next attempt
+// fails over.
+func subscribe(chain configs.Chain, filter ethereum.FilterQuery) (*rpcpool.Endpoint, rpcpool.Client, ethereum.Subscription, chan types.Log, error) {
+	endpoint, client, err := rpcpool.Websocket(chain).Dial(context.Background())
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
 
-	logs := make(chan types.Log)
-	_, err = client.SubscribeFilterLogs(ctx, filter, logs)
+	subLogs := make(chan types.Log)
+	start := time.Now()
+	sub, err := client.SubscribeFilterLogs(context.Background(), filter, subLogs)
+	endpoint.Report(time.Since(start), err)
 	if err != nil {
-		return nil, errors.New("failed to subscribe to transfer events: " + err.Error())
+		client.Close()
+		return nil, nil, nil, nil, err
 	}
 
-	return logs, nil
+	return endpoint, client, sub, subLogs, nil
+}
+
+// backfillGap re-reads everything from the last block seen, which may only have
+// been partially delivered, up to the current head. The head is returned even
+// when no logs were found, so the next reconnect does not scan the range again.
+func backfillGap(chain configs.Chain, lastBlock uint64, logs chan<- types.Log) (uint64, error) {
+	logger.Logger.Infof("[Subscriber] [%s] Backfilling events since block %v", chain.Name, lastBlock)
+
+	latestBlock, err := collectors.GetLatestBlockNumber(chain)
+	if err != nil {
+		return lastBlock, err
+	}
+
+	missedLogs, err := collectors.GetTransferLogs(chain, int64(lastBlock), latestBlock)
+	if err != nil {
+		return lastBlock, err
+	}
+
+	for _, vLog := range missedLogs {
+		logs <- vLog
+	}
+
+	if uint64(latestBlock) > lastBlock {
+		lastBlock = uint64(latestBlock)
+	}
+	return lastBlock, nil
+}
+
+func forwardLogs(chain configs.Chain, sub ethereum.Subscription, subLogs <-chan types.Log, logs chan<- types.Log, lastBlock uint64) (uint64, error) {
+	for {
+		select {
+		case err := <-sub.Err():
+			logger.Logger.Warnf("[Subscriber] [%s] Subscription dropped: %v", chain.Name, err)
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			return lastBlock, err
+		case vLog := <-subLogs:
+			if !vLog.Removed && vLog.BlockNumber > lastBlock {
+				lastBlock = vLog.BlockNumber
+			}
+			logs <- vLog
+		}
+	}
+}
+
+func nextReconnectDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxReconnectDelay {
+		return maxReconnectDelay
+	}
+	return delay
 }
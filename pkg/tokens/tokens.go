@@ -0,0 +1,312 @@
+package tokens
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/junwei0117/logs-collector/contracts/token"
+	"github.com/junwei0117/logs-collector/pkg/configs"
+	"github.com/junwei0117/logs-collector/pkg/logger"
+	"github.com/junwei0117/logs-collector/pkg/rpcpool"
+	"github.com/junwei0117/logs-collector/pkg/storage"
+)
+
+type tokenKey struct {
+	chainID uint64
+	address common.Address
+}
+
+// ErrNotToken is returned for addresses that have no code and no indexed
+// transfers, which are neither stored nor cached.
+var ErrNotToken = errors.New("address is not a token contract")
+
+// cacheCapacity bounds the tokens kept in memory, as any address can be
+// requested through the API.
+const cacheCapacity = 10000
+
+// Token metadata does not change once deployed, so the most recently used
+// tokens are cached for the lifetime of the process.
+var cache = struct {
+	sync.Mutex
+	entries map[tokenKey]*list.Element
+	order   *list.List
+}{
+	entries: make(map[tokenKey]*list.Element),
+	order:   list.New(),
+}
+
+func getCached(key tokenKey) (*storage.Token, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+
+	return element.Value.(*storage.Token), true
+}
+
+func addCached(key tokenKey, tokenInfo *storage.Token) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		element.Value = tokenInfo
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(tokenInfo)
+
+	if cache.order.Len() > cacheCapacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		oldestToken := oldest.Value.(*storage.Token)
+		delete(cache.entries, tokenKey{chainID: oldestToken.ChainID, address: oldestToken.Address})
+	}
+}
+
+// Concurrent misses of the same token share a single lookup.
+var tokenRequests singleflight.Group
+
+// GetToken returns the metadata of a token contract, reading it from the chain
+// the first time the contract is seen. Addresses without code or indexed
+// transfers fail with ErrNotToken.
+func GetToken(ctx context.Context, chain configs.Chain, address common.Address) (*storage.Token, error) {
+	key := tokenKey{chainID: chain.ChainID, address: address}
+
+	if cached, ok := getCached(key); ok {
+		return cached, nil
+	}
+
+	result, err, _ := tokenRequests.Do(chain.Name+"/"+address.Hex(), func() (interface{}, error) {
+		store, err := storage.GetStore()
+		if err != nil {
+			return nil, err
+		}
+
+		tokenInfo, err := store.GetToken(ctx, chain.ChainID, address)
+		if err != nil {
+			return nil, err
+		}
+
+		if tokenInfo == nil {
+			isToken, err := isTokenContract(ctx, store, chain, address)
+			if err != nil {
+				return nil, err
+			}
+			if !isToken {
+				return nil, ErrNotToken
+			}
+
+			tokenInfo, err = fetchToken(ctx, chain, address)
+			if err != nil {
+				return nil, err
+			}
+
+			err = store.SaveToken(ctx, tokenInfo)
+			if err != nil {
+				logger.Logger.Warnf("failed to store token %s: %v", address.Hex(), err)
+			}
+		}
+
+		addCached(key, tokenInfo)
+
+		return tokenInfo, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*storage.Token), nil
+}
+
+// isTokenContract tells whether address emitted indexed transfers or, failing
+// that, has code deployed.
+func isTokenContract(ctx context.Context, store storage.Store, chain configs.Chain, address common.Address) (bool, error) {
+	transfers, err := store.CountTransfers(ctx, storage.TransferFilter{ChainID: &chain.ChainID, Contract: &address})
+	if err != nil {
+		return false, err
+	}
+	if transfers > 0 {
+		return true, nil
+	}
+
+	var code []byte
+	err = rpcpool.HTTP(chain).Do(ctx, func(client rpcpool.Client) error {
+		code, err = client.CodeAt(ctx, address, nil)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(code) > 0, nil
+}
+
+// endpointCaller remembers the last failure of the endpoint itself, telling
+// it apart from calls the contract rejects.
+type endpointCaller struct {
+	client rpcpool.Client
+	err    error
+}
+
+func (caller *endpointCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	code, err := caller.client.CodeAt(ctx, contract, blockNumber)
+	if rpcpool.IsEndpointError(err) {
+		caller.err = err
+	}
+	return code, err
+}
+
+func (caller *endpointCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	output, err := caller.client.CallContract(ctx, call, blockNumber)
+	if rpcpool.IsEndpointError(err) {
+		caller.err = err
+	}
+	return output, err
+}
+
+// fetchToken reads the name, symbol and decimals of a contract. Contracts such
+// as ERC-721 collections implement only some of them, so a reverted call
+// leaves the field empty.
+func fetchToken(ctx context.Context, chain configs.Chain, address common.Address) (*storage.Token, error) {
+	tokenInfo := &storage.Token{ChainID: chain.ChainID, Address: address}
+
+	err := rpcpool.HTTP(chain).Do(ctx, func(client rpcpool.Client) error {
+		caller := &endpointCaller{client: client}
+		tokenCaller, err := token.NewTokenCaller(address, caller)
+		if err != nil {
+			return err
+		}
+
+		opts := &bind.CallOpts{Context: ctx}
+
+		name, err := tokenCaller.Name(opts)
+		if caller.err != nil {
+			return caller.err
+		}
+		if err == nil {
+			tokenInfo.Name = name
+		}
+
+		symbol, err := tokenCaller.Symbol(opts)
+		if caller.err != nil {
+			return caller.err
+		}
+		if err == nil {
+			tokenInfo.Symbol = symbol
+		}
+
+		decimals, err := tokenCaller.Decimals(opts)
+		if caller.err != nil {
+			return caller.err
+		}
+		if err == nil {
+			tokenInfo.Decimals = &decimals
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokenInfo.UpdatedAt = time.Now().Unix()
+
+	return tokenInfo, nil
+}
+
+// BalanceOf returns the ERC-20 balance of account after blockNumber, as
+// reported by the contract.
+func BalanceOf(chain configs.Chain, contract common.Address, account common.Address, blockNumber uint64) (*big.Int, error) {
+	var balance *big.Int
+	err := rpcpool.HTTP(chain).Do(context.Background(), func(client rpcpool.Client) error {
+		tokenCaller, err := token.NewTokenCaller(contract, client)
+		if err != nil {
+			return err
+		}
+
+		balance, err = tokenCaller.BalanceOf(callAt(blockNumber), account)
+		return err
+	})
+
+	return balance, err
+}
+
+// TotalSupply returns the ERC-20 total supply after blockNumber, as reported
+// by the contract.
+func TotalSupply(chain configs.Chain, contract common.Address, blockNumber uint64) (*big.Int, error) {
+	var supply *big.Int
+	err := rpcpool.HTTP(chain).Do(context.Background(), func(client rpcpool.Client) error {
+		tokenCaller, err := token.NewTokenCaller(contract, client)
+		if err != nil {
+			return err
+		}
+
+		supply, err = tokenCaller.TotalSupply(callAt(blockNumber))
+		return err
+	})
+
+	return supply, err
+}
+
+// Allowance returns what spender may still transfer on behalf of owner at the
+// latest block. Transfers made with transferFrom reduce it without emitting an
+// Approval event.
+func Allowance(chain configs.Chain, contract common.Address, owner common.Address, spender common.Address) (*big.Int, error) {
+	var allowance *big.Int
+	err := rpcpool.HTTP(chain).Do(context.Background(), func(client rpcpool.Client) error {
+		tokenCaller, err := token.NewTokenCaller(contract, client)
+		if err != nil {
+			return err
+		}
+
+		allowance, err = tokenCaller.Allowance(&bind.CallOpts{Context: context.Background()}, owner, spender)
+		return err
+	})
+
+	return allowance, err
+}
+
+func callAt(blockNumber uint64) *bind.CallOpts {
+	return &bind.CallOpts{Context: context.Background(), BlockNumber: new(big.Int).SetUint64(blockNumber)}
+}
+
+// FormatAmount renders a base-unit amount in whole tokens, e.g. 1500000 with 6
+// decimals as "1.5".
+func FormatAmount(value *big.Int, decimals uint8) string {
+	if value == nil {
+		return "0"
+	}
+
+	digits := new(big.Int).Abs(value).String()
+	if len(digits) <= int(decimals) {
+		digits = strings.Repeat("0", int(decimals)-len(digits)+1) + digits
+	}
+
+	whole := digits[:len(digits)-int(decimals)]
+	fraction := strings.TrimRight(digits[len(digits)-int(decimals):], "0")
+
+	amount := whole
+	if fraction != "" {
+		amount += "." + fraction
+	}
+	if value.Sign() < 0 {
+		amount = "-" + amount
+	}
+
+	return amount
+}
@@ -0,0 +1,34 @@
+package tokens
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		value    string
+		decimals uint8
+		want     string
+	}{
+		{"1500000", 6, "1.5"},
+		{"1000000", 6, "1"},
+		{"1", 6, "0.000001"},
+		{"0", 18, "0"},
+		{"123", 0, "123"},
+		{"-2500", 3, "-2.5"},
+		{"1000000000000000000000000", 18, "1000000"},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639935", 18, "115792089237316195423570985008687907853269984665640564039457.584007913129639935"},
+	}
+
+	for _, test := range tests {
+		value, _ := new(big.Int).SetString(test.value, 10)
+		if got := FormatAmount(value, test.decimals); got != test.want {
+			t.Errorf("FormatAmount(%v, %v) = %q, want %q", test.value, test.decimals, got, test.want)
+		}
+	}
+
+	if got := FormatAmount(nil, 18); got != "0" {
+		t.Errorf("FormatAmount(nil, 18) = %q, want %q", got, "0")
+	}
+}